@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	answerAction   = "a"
+	blackoutAction = "b"
+	stopAction     = "s"
+)
+
+// QuizCallback is data of quiz keyboard button, it tells which card of which quiz is answered
+type QuizCallback struct {
+	Action string
+	QuizID string
+	// Card answered by the button, see cardKey
+	Card string
+	// Position of chosen answer on keyboard
+	Option int
+}
+
+// Encoded like "a|gzv1c9wq3k0w|6160f0c2a4b3e1d2c3b4a596r|2", Telegram rejects callback data longer than 64 bytes
+func (callback QuizCallback) String() string {
+
+	return strings.Join([]string{
+		callback.Action,
+		callback.QuizID,
+		callback.Card,
+		strconv.Itoa(callback.Option),
+	}, "|")
+}
+
+func parseQuizCallback(data string) (callback QuizCallback, ok bool) {
+
+	parts := strings.Split(data, "|")
+	if len(parts) != 4 {
+		return callback, false
+	}
+
+	if parts[0] != answerAction && parts[0] != blackoutAction && parts[0] != stopAction {
+		return callback, false
+	}
+
+	option, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return callback, false
+	}
+
+	return QuizCallback{Action: parts[0], QuizID: parts[1], Card: parts[2], Option: option}, true
+}
+
+// Fact ID, reverse card of the fact has "r" at the end, so both cards of one fact differ
+func cardKey(fact Fact) string {
+
+	key := fact.ID.Hex()
+	if fact.Reversed {
+		key += "r"
+	}
+
+	return key
+}
+
+// Every quiz round gets new ID, so buttons of previous rounds become stale
+func newQuizID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
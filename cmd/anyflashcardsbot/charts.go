@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Charts are drawn with standard library only, numbers use tiny built-in font
+
+var (
+	chartBackground = color.RGBA{255, 255, 255, 255}
+	chartAxis       = color.RGBA{120, 120, 120, 255}
+	chartBar        = color.RGBA{66, 133, 244, 255}
+	chartText       = color.RGBA{60, 60, 60, 255}
+	// Heatmap levels from no answers to many answers, like GitHub contributions
+	heatmapLevels = []color.RGBA{
+		{235, 237, 240, 255},
+		{155, 233, 168, 255},
+		{64, 196, 99, 255},
+		{48, 161, 78, 255},
+		{33, 110, 57, 255},
+	}
+)
+
+var (
+	forecastDays = 30
+	heatmapWeeks = 26
+)
+
+// Digits 3x5, every row is three bits from left to right
+var chartDigits = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'.': {0, 0, 0, 0, 2},
+}
+
+func newChart(width, height int) *image.RGBA {
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	return img
+}
+
+func fillRect(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	draw.Draw(img, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// Draw number with left top corner at x, y, every font pixel is scale pixels wide
+func drawNumber(img *image.RGBA, x, y int, text string, scale int, c color.RGBA) {
+
+	for _, r := range text {
+		glyph, ok := chartDigits[r]
+		if !ok {
+			x += 4 * scale
+			continue
+		}
+		for row, bits := range glyph {
+			for column := 0; column < 3; column++ {
+				if bits&(4>>column) != 0 {
+					fillRect(img, image.Rect(x+column*scale, y+row*scale, x+(column+1)*scale, y+(row+1)*scale), c)
+				}
+			}
+		}
+		x += 4 * scale
+	}
+}
+
+// Bars with max value on the left and label under every labelEvery bar
+func drawBarChart(values []int, labels []string, labelEvery int) *image.RGBA {
+
+	const (
+		barWidth = 20
+		gap      = 4
+		height   = 300
+		left     = 50
+		bottom   = 30
+		top      = 20
+		scale    = 2
+	)
+
+	width := left + len(values)*(barWidth+gap) + gap
+	img := newChart(width, height)
+
+	max := 0
+	for _, value := range values {
+		if value > max {
+			max = value
+		}
+	}
+
+	chartHeight := height - top - bottom
+	fillRect(img, image.Rect(left, height-bottom, width, height-bottom+1), chartAxis)
+	fillRect(img, image.Rect(left-1, top, left, height-bottom), chartAxis)
+	drawNumber(img, 4, top, strconv.Itoa(max), scale, chartText)
+	drawNumber(img, 4, height-bottom-5*scale, "0", scale, chartText)
+
+	for i, value := range values {
+		x := left + gap + i*(barWidth+gap)
+		if max > 0 && value > 0 {
+			barHeight := int(math.Max(1, float64(value*chartHeight/max)))
+			fillRect(img, image.Rect(x, height-bottom-barHeight, x+barWidth, height-bottom), chartBar)
+		}
+		if i%labelEvery == 0 && i < len(labels) {
+			drawNumber(img, x, height-bottom+8, labels[i], scale, chartText)
+		}
+	}
+
+	return img
+}
+
+// Due cards for every day from today, overdue cards are counted today
+func forecastChart(cards FactSet, now time.Time) *image.RGBA {
+
+	values := make([]int, forecastDays)
+	for _, card := range cards {
+		if card.IntervalFrom == "" {
+			continue
+		}
+		scheduler := schedulerByName(card.Scheduler)
+		for day := 0; day < forecastDays; day++ {
+			if scheduler.Due(&card.FactMetadata, now.AddDate(0, 0, day)) {
+				values[day]++
+				break
+			}
+		}
+	}
+
+	labels := make([]string, forecastDays)
+	for day := range labels {
+		labels[day] = strconv.Itoa(day)
+	}
+
+	return drawBarChart(values, labels, 5)
+}
+
+// Number of reviewed cards in every easiness range of SM-2, from 1.3 to 3.0 and higher
+func easeChart(cards FactSet) *image.RGBA {
+
+	const (
+		minEf  = 1.3
+		maxEf  = 3.0
+		bucket = 0.1
+	)
+
+	values := make([]int, int(math.Round((maxEf-minEf)/bucket))+1)
+	labels := make([]string, len(values))
+	for i := range labels {
+		labels[i] = strconv.FormatFloat(minEf+float64(i)*bucket, 'f', 1, 64)
+	}
+
+	for _, card := range cards {
+		if card.IntervalFrom == "" {
+			continue
+		}
+		i := int(math.Round((card.Ef - minEf) / bucket))
+		if i < 0 {
+			i = 0
+		} else if i >= len(values) {
+			i = len(values) - 1
+		}
+		values[i]++
+	}
+
+	return drawBarChart(values, labels, 4)
+}
+
+// Squares for every day of last weeks, columns are weeks and rows are weekdays from Monday,
+// days are counted in location of now
+func heatmapChart(reviews []Review, now time.Time) *image.RGBA {
+
+	const (
+		cell   = 14
+		gap    = 3
+		margin = 10
+	)
+
+	days := map[string]int{}
+	max := 0
+	for _, review := range reviews {
+		day := review.Date.In(now.Location()).Format("2006-01-02")
+		days[day]++
+		if days[day] > max {
+			max = days[day]
+		}
+	}
+
+	width := 2*margin + heatmapWeeks*(cell+gap)
+	height := 2*margin + 7*(cell+gap)
+	img := newChart(width, height)
+
+	// The last column is current week
+	weekday := (int(now.Weekday()) + 6) % 7
+	start := now.AddDate(0, 0, -weekday-(heatmapWeeks-1)*7)
+
+	for week := 0; week < heatmapWeeks; week++ {
+		for day := 0; day < 7; day++ {
+			date := start.AddDate(0, 0, week*7+day)
+			if date.After(now) {
+				continue
+			}
+
+			level := 0
+			if answers := days[date.Format("2006-01-02")]; answers > 0 && max > 0 {
+				level = 1 + (answers-1)*(len(heatmapLevels)-1)/max
+			}
+
+			x := margin + week*(cell+gap)
+			y := margin + day*(cell+gap)
+			fillRect(img, image.Rect(x, y, x+cell, y+cell), heatmapLevels[level])
+		}
+	}
+
+	return img
+}
+
+func sendChart(bot *tgbotapi.BotAPI, userId int, img image.Image, name string, caption string) error {
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return err
+	}
+
+	photo := tgbotapi.NewPhotoUpload(int64(userId), tgbotapi.FileBytes{Name: name, Bytes: buffer.Bytes()})
+	photo.Caption = caption
+	if _, err := bot.Send(photo); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func showChart(bot *tgbotapi.BotAPI, userId int, chart string) error {
+
+	now := userNow(userId)
+
+	switch chart {
+	case "chartForecast", "chartEase":
+		cards, err := loadFactsFromBase(&tgbotapi.User{ID: userId})
+		if err != nil {
+			return err
+		}
+		if chart == "chartEase" {
+			return sendChart(bot, userId, easeChart(cards), "ease.png", "Cards by easiness, left ones are hard for you")
+		}
+		return sendChart(bot, userId, forecastChart(cards, now), "forecast.png", "Cards due in the next "+strconv.Itoa(forecastDays)+" days, from today")
+
+	case "chartHeatmap":
+		reviews, err := loadReviewsFromBase(userId, now.AddDate(0, 0, -heatmapWeeks*7))
+		if err != nil {
+			return err
+		}
+		return sendChart(bot, userId, heatmapChart(reviews, now), "heatmap.png", "Your answers of last "+strconv.Itoa(heatmapWeeks)+" weeks")
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"strconv"
 	"time"
@@ -25,10 +26,17 @@ var (
 )
 
 var (
-	Client            *mongo.Client
-	database          *mongo.Database
-	libraryCollection *mongo.Collection
-	usersCollection   *mongo.Collection
+	Client             *mongo.Client
+	database           *mongo.Database
+	libraryCollection  *mongo.Collection
+	usersCollection    *mongo.Collection
+	sessionsCollection *mongo.Collection
+	progressCollection *mongo.Collection
+	reviewsCollection  *mongo.Collection
+	// Jobs of reminder service, see ReminderJob
+	remindersCollection *mongo.Collection
+	// Sessions shared by replicas, see mongoSessionStore
+	userSessionsCollection *mongo.Collection
 )
 
 func connectMongoDb() error {
@@ -42,6 +50,11 @@ func connectMongoDb() error {
 	database = Client.Database("anyflashcardsbot")
 	libraryCollection = database.Collection("library")
 	usersCollection = database.Collection("users")
+	sessionsCollection = database.Collection("sessions")
+	progressCollection = database.Collection("progress")
+	reviewsCollection = database.Collection("reviews")
+	remindersCollection = database.Collection("reminders")
+	userSessionsCollection = database.Collection("userSessions")
 
 	// Check the connection
 	err = Client.Ping(context.TODO(), nil)
@@ -49,6 +62,23 @@ func connectMongoDb() error {
 		return err
 	}
 	log.Printf("MongoDB server connected!")
+
+	if err = createProgressIndex(); err != nil {
+		return err
+	}
+	if err = createReviewsIndex(); err != nil {
+		return err
+	}
+	if err = createRemindersIndexes(); err != nil {
+		return err
+	}
+	if err = createSessionsIndex(); err != nil {
+		return err
+	}
+	if err = createUserSessionsIndex(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -57,7 +87,45 @@ type User struct {
 	User             tgbotapi.User       `bson:"user"`
 	NativeChatMember tgbotapi.ChatMember `bson:"native_chat_member"`
 	Dictionary       string              `bson:"dictionary"`
-	ReminderTime     string              `bson:"reminder_time"`
+	// Single reminder of old versions, it is used until user edits Reminders
+	ReminderTime string `bson:"reminder_time"`
+	// Choose answer from keyboard or type it, see quizModeChoice and quizModeTyping
+	QuizMode string `bson:"quiz_mode"`
+	// Number of answers on quiz keyboard, see answerLayouts
+	AnswerOptions int `bson:"answer_options"`
+	// Pointers to dictionaries in library, picking dictionary doesn't copy it
+	Dictionaries []UserDictionary `bson:"dictionaries,omitempty"`
+	// How many dictionaries user can keep, defaultDictionaryQuota if it isn't set, see /setquota
+	DictionaryQuota int `bson:"dictionary_quota,omitempty"`
+	// Daily limits, defaults are used if they aren't set, see limits.go
+	NewCardsPerDay int        `bson:"new_cards_per_day,omitempty"`
+	ReviewsPerDay  int        `bson:"reviews_per_day,omitempty"`
+	DailyCount     DailyCount `bson:"daily"`
+	// Scheduling algorithm, see schedulers
+	Scheduler string `bson:"scheduler,omitempty"`
+	// IANA name like Europe/Kiev, default location is used if it is empty
+	Timezone string `bson:"timezone,omitempty"`
+	// Times of day in user timezone for reminding
+	Reminders []Reminder `bson:"reminders,omitempty"`
+	// Nudge in the evening when streak is going to be broken, see streakReminderTime
+	StreakReminder bool `bson:"streak_reminder,omitempty"`
+	// Number of the last ReminderService.Set call for user, jobs of older calls are stale
+	ReminderGeneration int64 `bson:"reminder_generation,omitempty"`
+}
+
+type UserDictionary struct {
+	DictionaryID primitive.ObjectID `bson:"dictionaryId"`
+	// Current or private, see dictStatuses, several dictionaries can be current
+	Status string `bson:"status"`
+	// Learn reverse cards (answer -> question) too
+	Reverse bool      `bson:"reverse"`
+	Date    time.Time `bson:"date"`
+	// Name given by user, name of dictionary in library is used if it is empty
+	Name string `bson:"name,omitempty"`
+	// Max cards for review from this dictionary per day, 0 is no limit, see DailyCount.Dictionaries
+	Limit int `bson:"limit,omitempty"`
+	// Overrides scheduler of user, see schedulers
+	Scheduler string `bson:"scheduler,omitempty"`
 }
 
 /*
@@ -96,31 +164,257 @@ func loadAllUsersStatusFromBase() (map[int]string, error) {
 	return statuses, nil
 }
 
-func loadAllRemindsFromBase() (map[int]string, error) {
-	reminds := map[int]string{}
-	users, err := usersCollection.Find(context.TODO(), bson.M{})
+func loadUserFromBase(userId int) (user User, err error) {
+
+	err = usersCollection.FindOne(context.TODO(), bson.M{"user.id": userId}).Decode(&user)
+
+	return user, err
+}
+
+func dumpQuizModeToBase(userId int, quizMode string) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$set": bson.M{"quiz_mode": quizMode}},
+	)
 	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func dumpAnswerOptionsToBase(userId int, answerOptions int) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$set": bson.M{"answer_options": answerOptions}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func dumpSchedulerToBase(userId int, scheduler string) error {
+
+	if _, ok := schedulers[scheduler]; !ok {
+		return fmt.Errorf("unknown scheduler %v", scheduler)
+	}
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$set": bson.M{"scheduler": scheduler}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func loadUserDictionariesFromBase(userId int) ([]UserDictionary, error) {
+
+	user, err := loadUserFromBase(userId)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
-	for users.Next(context.TODO()) {
-		var user User
-		if err = users.Decode(&user); err != nil {
-			return nil, err
+
+	return user.Dictionaries, nil
+}
+
+func findUserDictionary(userDictionaries []UserDictionary, dictionaryId primitive.ObjectID) *UserDictionary {
+
+	for i := range userDictionaries {
+		if userDictionaries[i].DictionaryID == dictionaryId {
+			return &userDictionaries[i]
+		}
+	}
+
+	return nil
+}
+
+// Add pointer to dictionary for user, current one is used for learning
+func addUserDictionaryInBase(userId int, dictionaryId primitive.ObjectID, current bool) error {
+
+	status := "private"
+	if current {
+		status = "current"
+
+		// Only one dictionary is current
+		_, err := usersCollection.UpdateOne(
+			context.TODO(),
+			bson.M{"user.id": userId, "dictionaries.0": bson.M{"$exists": true}},
+			bson.M{"$set": bson.M{"dictionaries.$[].status": "private"}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId, "dictionaries.dictionaryId": dictionaryId},
+		bson.M{"$set": bson.M{"dictionaries.$.status": status}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		_, err = usersCollection.UpdateOne(
+			context.TODO(),
+			bson.M{"user.id": userId},
+			bson.M{"$push": bson.M{"dictionaries": UserDictionary{DictionaryID: dictionaryId, Status: status, Date: time.Now()}}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Picked dictionary is the only current one
+func pickUserDictionaryInBase(userId int, dictionaryId primitive.ObjectID) error {
+	return addUserDictionaryInBase(userId, dictionaryId, true)
+}
+
+// Make dictionary current or not, other current dictionaries stay current
+func activateUserDictionaryInBase(userId int, dictionaryId primitive.ObjectID, active bool) error {
+
+	status := "private"
+	if active {
+		status = "current"
+	}
+
+	result, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId, "dictionaries.dictionaryId": dictionaryId},
+		bson.M{"$set": bson.M{"dictionaries.$.status": status}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		_, err = usersCollection.UpdateOne(
+			context.TODO(),
+			bson.M{"user.id": userId},
+			bson.M{"$push": bson.M{"dictionaries": UserDictionary{DictionaryID: dictionaryId, Status: status, Date: time.Now()}}},
+		)
+		if err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+// Empty scheduler means scheduler of user
+func setUserDictionarySchedulerInBase(userId int, dictionaryId primitive.ObjectID, scheduler string) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId, "dictionaries.dictionaryId": dictionaryId},
+		bson.M{"$set": bson.M{"dictionaries.$.scheduler": scheduler}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func setUserDictionaryLimitInBase(userId int, dictionaryId primitive.ObjectID, limit int) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId, "dictionaries.dictionaryId": dictionaryId},
+		bson.M{"$set": bson.M{"dictionaries.$.limit": limit}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func setUserDictionaryReverseInBase(userId int, dictionaryId primitive.ObjectID, reverse bool) error {
+
+	result, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId, "dictionaries.dictionaryId": dictionaryId},
+		bson.M{"$set": bson.M{"dictionaries.$.reverse": reverse}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Default dictionary is used without pointer until user changes something,
+	// it stays current only while user has no other current dictionary
+	status := "private"
+	dictionaries, err := loadUserDictionariesFromBase(userId)
+	if err != nil {
+		return err
+	}
+	if dictionaryId == defaultDictionaryId && !hasCurrentDictionary(dictionaries) {
+		status = "current"
+	}
+
+	_, err = usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$push": bson.M{"dictionaries": UserDictionary{DictionaryID: dictionaryId, Status: status, Reverse: reverse, Date: time.Now()}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func hasCurrentDictionary(userDictionaries []UserDictionary) bool {
 
-		if user.ReminderTime != "" {
-			reminds[user.User.ID] = user.ReminderTime
+	for _, userDictionary := range userDictionaries {
+		if userDictionary.Status == "current" {
+			return true
 		}
 	}
-	return reminds, err
+
+	return false
 }
 
-func dumpReminderToBase(userId int, time string) error {
+func renameUserDictionaryInBase(userId int, dictionaryId primitive.ObjectID, name string) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId, "dictionaries.dictionaryId": dictionaryId},
+		bson.M{"$set": bson.M{"dictionaries.$.name": name}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Remove pointer only, dictionary stays in library and progress is kept
+func removeUserDictionaryInBase(userId int, dictionaryId primitive.ObjectID) error {
 
 	_, err := usersCollection.UpdateOne(
 		context.TODO(),
 		bson.M{"user.id": userId},
-		bson.M{"$set": bson.M{"reminder_time": time}},
+		bson.M{"$pull": bson.M{"dictionaries": bson.M{"dictionaryId": dictionaryId}}},
 	)
 	if err != nil {
 		return err
@@ -129,6 +423,60 @@ func dumpReminderToBase(userId int, time string) error {
 	return nil
 }
 
+func loadDictionaryQuotaFromBase(userId int) (int, error) {
+
+	user, err := loadUserFromBase(userId)
+	if err == mongo.ErrNoDocuments {
+		return defaultDictionaryQuota, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if user.DictionaryQuota > 0 {
+		return user.DictionaryQuota, nil
+	}
+
+	return defaultDictionaryQuota, nil
+}
+
+// Zero quota is default one
+func dumpDictionaryQuotaToBase(userId int, quota int) error {
+
+	update := bson.M{"$set": bson.M{"dictionary_quota": quota}}
+	if quota == 0 {
+		update = bson.M{"$unset": bson.M{"dictionary_quota": ""}}
+	}
+
+	result, err := usersCollection.UpdateOne(context.TODO(), bson.M{"user.id": userId}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// Dictionaries already kept by user don't take more space
+func dictionaryQuotaReached(userId int, dictionaryId primitive.ObjectID) (bool, error) {
+
+	userDictionaries, err := loadUserDictionariesFromBase(userId)
+	if err != nil {
+		return false, err
+	}
+	if findUserDictionary(userDictionaries, dictionaryId) != nil {
+		return false, nil
+	}
+
+	quota, err := loadDictionaryQuotaFromBase(userId)
+	if err != nil {
+		return false, err
+	}
+
+	return len(userDictionaries) >= quota, nil
+}
+
 var defaultDictionaryPath = "./configs/dictionaries/owsi.csv"
 
 func addNewUsers(bot *tgbotapi.BotAPI, newUsers *[]tgbotapi.User) error {
@@ -233,15 +581,30 @@ type DictionaryMetadata struct {
 	OwnerID  int    `bson:"ownerId"`
 	// Public or private, private is available only for owner
 	Status string `bson:"status"`
-	// Default dictionary for all users
+	// Learn reverse cards too, it is taken from user dictionary and isn't stored
+	Reverse bool `bson:"-"`
+	// Max cards for review per day, it is taken from user dictionary and isn't stored
+	Limit int `bson:"-"`
+	// Scheduler of user dictionary or of user, it isn't stored
+	Scheduler string `bson:"-"`
 }
 
 type FactSet []Fact
 
 type Fact struct {
-	Question string
-	Answer   string
+	// Stable within dictionary and its copies, progress is updated by it
+	ID primitive.ObjectID `bson:"id"`
+	// Dictionary the fact is loaded from, isn't stored in library
+	DictionaryID primitive.ObjectID `bson:"dictionaryId,omitempty"`
+	Question     string
+	Answer       string
 	FactMetadata
+	// Progress of reverse card, it is scheduled independently
+	Reverse FactMetadata `bson:"reverse"`
+	// Card is reverse one, question and answer are swapped
+	Reversed bool `bson:"reversed,omitempty"`
+	// Scheduler of the dictionary the fact is loaded from, isn't stored in library
+	Scheduler string `bson:"scheduler,omitempty"`
 }
 
 type FactMetadata struct {
@@ -255,27 +618,81 @@ type FactMetadata struct {
 	IntervalFrom string `bson:"intervalFrom"`
 	// number of times this fact has been presented; reset to 0 on failed answer.
 	N int `bson:"n"`
+	// Layout version, fields above are SM-2 state and are kept in every version
+	Version int `bson:"version,omitempty"`
+	// State of other schedulers, see scheduler.go
+	FSRS    FSRSState    `bson:"fsrs,omitempty"`
+	Leitner LeitnerState `bson:"leitner,omitempty"`
 }
 
-func convertToSupermemoFactSet(factSet *FactSet) *supermemo.FactSet {
+// Assess updates metadata of the fact by every scheduler, now is in user timezone
+func (fact *Fact) Assess(quality int, now time.Time) {
 
-	var smFactSet supermemo.FactSet
+	upgradeFactMetadata(&fact.FactMetadata)
+	for _, name := range schedulerNames {
+		schedulers[name].Assess(&fact.FactMetadata, quality, now)
+	}
+}
 
-	for _, fact := range *factSet {
+// ForReview returns shuffled facts which are up for review at now by their scheduler
+func (factSet FactSet) ForReview(now time.Time) FactSet {
 
-		q := fact.Question
-		a := fact.Answer
-		ef := fact.Ef
-		n := fact.N
-		interval := fact.Interval
-		intervalFrom := fact.IntervalFrom
+	var subset FactSet
+	for _, fact := range factSet {
+		if schedulerByName(fact.Scheduler).Due(&fact.FactMetadata, now) {
+			subset = append(subset, fact)
+		}
+	}
 
-		smFact, _ := supermemo.LoadFact(q, a, ef, n, interval, intervalFrom)
+	shuffled := make(FactSet, len(subset))
+	for i, j := range rand.Perm(len(subset)) {
+		shuffled[i] = subset[j]
+	}
 
-		smFactSet = append(smFactSet, smFact)
+	return shuffled
+}
 
+// ForReview returns cards up for review today, no more than left of dictionary limit
+// after cards answered today
+func (dictionary *Dictionary) ForReview(now time.Time, answeredToday int) FactSet {
+
+	forReview := dictionary.Cards().ForReview(now)
+	if dictionary.DictionaryMetadata.Limit == 0 {
+		return forReview
+	}
+
+	left := dictionary.DictionaryMetadata.Limit - answeredToday
+	if left < 0 {
+		left = 0
+	}
+	if len(forReview) > left {
+		forReview = forReview[:left]
+	}
+
+	return forReview
+}
+
+// Cards returns facts for learning, with reverse cards if they are turned on
+func (dictionary *Dictionary) Cards() FactSet {
+
+	cards := make(FactSet, 0, len(dictionary.FactSet))
+	cards = append(cards, dictionary.FactSet...)
+
+	if dictionary.DictionaryMetadata.Reverse {
+		for _, fact := range dictionary.FactSet {
+			cards = append(cards, Fact{
+				ID:           fact.ID,
+				DictionaryID: fact.DictionaryID,
+				Question:     fact.Answer,
+				Answer:       fact.Question,
+				FactMetadata: fact.Reverse,
+				Reversed:     true,
+				Scheduler:    fact.Scheduler,
+			})
+		}
 	}
-	return &smFactSet
+
+	return cards
 }
 
 func convertToFactSet(smFactSet *supermemo.FactSet) FactSet {
@@ -300,54 +717,207 @@ func convertToFactSet(smFactSet *supermemo.FactSet) FactSet {
 	return factSet
 }
 
-func updateFactsInBase(userId int, factSet *FactSet) error {
-	for _, fact := range *factSet {
+func (factSet FactSet) setDictionaryID(dictionaryId primitive.ObjectID) {
+	for i := range factSet {
+		factSet[i].DictionaryID = dictionaryId
+	}
+}
+
+// Facts which are the same as previous ones get their IDs, then facts with the same question
+// or answer get them, so fixing a typo in dictionary keeps progress
+func (factSet FactSet) keepIDs(previous FactSet) {
+
+	byPair := map[[2]string]primitive.ObjectID{}
+	byQuestion := map[string]primitive.ObjectID{}
+	byAnswer := map[string]primitive.ObjectID{}
+	for _, fact := range previous {
+		byPair[[2]string{fact.Question, fact.Answer}] = fact.ID
+		byQuestion[fact.Question] = fact.ID
+		byAnswer[fact.Answer] = fact.ID
+	}
 
-		_, err := libraryCollection.UpdateOne(
+	used := map[primitive.ObjectID]bool{}
+	keep := func(i int, id primitive.ObjectID, ok bool) {
+		if ok && !used[id] && !id.IsZero() && factSet[i].ID.IsZero() {
+			factSet[i].ID = id
+			used[id] = true
+		}
+	}
+	for i := range factSet {
+		id, ok := byPair[[2]string{factSet[i].Question, factSet[i].Answer}]
+		keep(i, id, ok)
+	}
+	for i := range factSet {
+		id, ok := byQuestion[factSet[i].Question]
+		keep(i, id, ok)
+		id, ok = byAnswer[factSet[i].Answer]
+		keep(i, id, ok)
+	}
+}
+
+// Give ID to each fact which hasn't it yet
+func (factSet FactSet) assignIDs() {
+	for i := range factSet {
+		if factSet[i].ID.IsZero() {
+			factSet[i].ID = primitive.NewObjectID()
+		}
+	}
+}
+
+// Dictionaries dumped before facts got IDs are updated once on startup
+func migrateFactIDsInBase() error {
+
+	cursor, err := libraryCollection.Find(
+		context.TODO(),
+		bson.M{"factSet": bson.M{"$elemMatch": bson.M{"id": bson.M{"$exists": false}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	var dictionaries []Dictionary
+	if err = cursor.All(context.TODO(), &dictionaries); err != nil {
+		return err
+	}
+
+	for _, dictionary := range dictionaries {
+		dictionary.FactSet.assignIDs()
+
+		_, err = libraryCollection.UpdateOne(
 			context.TODO(),
-			bson.M{"dictionaryMetadata.ownerId": userId, "dictionaryMetadata.status": "current", "factSet.question": fact.Question},
-			bson.M{"$set": bson.M{"factSet.$.factmetadata": fact.FactMetadata}},
+			bson.M{"_id": dictionary.ID},
+			bson.M{"$set": bson.M{"factSet": dictionary.FactSet}},
 		)
 		if err != nil {
-
 			return err
 		}
 	}
 
+	log.Printf("Dictionaries migrated to fact IDs: %v\n", len(dictionaries))
 	return nil
 }
 
+// Cards of all current dictionaries of user, each card keeps id of its dictionary
 func loadFactsFromBase(user *tgbotapi.User) (FactSet, error) {
 
-	var dictionary Dictionary
-	var err error
-	if err = libraryCollection.FindOne(
-		context.TODO(),
-		bson.M{"dictionaryMetadata.ownerId": user.ID, "dictionaryMetadata.status": "current"}).Decode(&dictionary); err != nil {
+	dictionaries, err := loadActiveDictionariesFromBase(user.ID)
+	if err != nil {
+		return nil, err
+	}
 
-		log.Panic(err)
+	var cards FactSet
+	for _, dictionary := range dictionaries {
+		cards = append(cards, dictionary.Cards()...)
+	}
+
+	return cards, nil
+}
+
+// Cards of all current dictionaries and cards for review among them, mixed together
+// and limited by what is left for today
+func loadQuizFromBase(userId int) (cards FactSet, forReview FactSet, err error) {
+
+	dictionaries, err := loadActiveDictionariesFromBase(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count, err := loadDailyCountFromBase(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := userNow(userId)
+	for _, dictionary := range dictionaries {
+		cards = append(cards, dictionary.Cards()...)
+		forReview = append(forReview, dictionary.ForReview(now, count.Dictionaries[dictionary.ID.Hex()])...)
+	}
+	rand.Shuffle(len(forReview), func(i, j int) { forReview[i], forReview[j] = forReview[j], forReview[i] })
+
+	forReview, err = applyDailyLimits(userId, forReview)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cards, forReview, nil
+}
+
+// All current dictionaries of user with user progress, default one if user hasn't picked any
+func loadActiveDictionariesFromBase(userId int) (dictionaries []Dictionary, err error) {
+
+	user, err := loadUserFromBase(userId)
+	if err != nil && err != mongo.ErrNoDocuments {
 		return nil, err
 	}
+	userDictionaries := user.Dictionaries
 
-	return dictionary.FactSet, err
+	for i := range userDictionaries {
+		if userDictionaries[i].Status != "current" {
+			continue
+		}
+		if userDictionaries[i].Scheduler == "" {
+			userDictionaries[i].Scheduler = user.Scheduler
+		}
+
+		dictionary, err := loadUserDictionaryFromBase(userId, &userDictionaries[i])
+		if err == mongo.ErrNoDocuments {
+			log.Printf("Dictionary %v of user %v isn't found\n", userDictionaries[i].DictionaryID.Hex(), userId)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		dictionaries = append(dictionaries, dictionary)
+	}
+
+	if len(dictionaries) == 0 {
+		dictionary, err := loadUserDictionaryFromBase(userId, &UserDictionary{DictionaryID: defaultDictionaryId, Scheduler: user.Scheduler})
+		if err != nil {
+			return nil, err
+		}
+		dictionaries = append(dictionaries, dictionary)
+	}
+
+	return dictionaries, nil
 }
 
-// Functions for Dictionary
-func updateDefaultLibrary(defaultLibraryDirPath string) error {
-	_, err := libraryCollection.DeleteMany(
-		context.TODO(),
-		bson.M{"$or": []interface{}{bson.M{"dictionaryMetadata.status": "library"}, bson.M{"dictionaryMetadata.status": "default"}}},
-	)
+// Dictionary from library with user progress and user settings
+func loadUserDictionaryFromBase(userId int, userDictionary *UserDictionary) (dictionary Dictionary, err error) {
 
+	dictionary, err = loadDictionaryFromBase(&userDictionary.DictionaryID)
 	if err != nil {
-		return err
+		return dictionary, err
+	}
+	if dictionary.ID.IsZero() {
+		return dictionary, mongo.ErrNoDocuments
+	}
+
+	progress, err := loadProgressFromBase(userId, dictionary.ID)
+	if err != nil {
+		return dictionary, err
+	}
+	joinProgress(&dictionary, progress)
+	dictionary.DictionaryMetadata.Reverse = userDictionary.Reverse
+	dictionary.DictionaryMetadata.Limit = userDictionary.Limit
+	dictionary.DictionaryMetadata.Scheduler = userDictionary.Scheduler
+	for i := range dictionary.FactSet {
+		dictionary.FactSet[i].Scheduler = userDictionary.Scheduler
 	}
+	if userDictionary.Name != "" {
+		dictionary.DictionaryMetadata.Name = userDictionary.Name
+	}
+
+	return dictionary, nil
+}
+
+// Functions for Dictionary
+func updateDefaultLibrary(defaultLibraryDirPath string) error {
 
 	csvDictionariesPathes, err := os.ReadDir(defaultLibraryDirPath)
 	if err != nil {
 		return err
 	}
 
+	var names []string
 	for _, csvDictionaryPath := range csvDictionariesPathes {
 
 		csvPath := defaultLibraryDirPath + "/" + csvDictionaryPath.Name()
@@ -361,7 +931,43 @@ func updateDefaultLibrary(defaultLibraryDirPath string) error {
 			dictionary.DictionaryMetadata.Status = "default"
 		}
 
-		dumpDictionaryToBase(&dictionary)
+		// Dictionary keeps its ID and IDs of facts, users point to them
+		var previous Dictionary
+		err = libraryCollection.FindOne(
+			context.TODO(),
+			bson.M{"dictionaryMetadata.name": dictionary.DictionaryMetadata.Name, "dictionaryMetadata.status": bson.M{"$in": []string{"library", "default"}}},
+		).Decode(&previous)
+
+		if err == mongo.ErrNoDocuments {
+			if _, err = dumpDictionaryToBase(&dictionary); err != nil {
+				return err
+			}
+
+		} else if err != nil {
+			return err
+
+		} else {
+			dictionary.ID = previous.ID
+			dictionary.FactSet.keepIDs(previous.FactSet)
+			dictionary.FactSet.assignIDs()
+			if _, err = libraryCollection.ReplaceOne(context.TODO(), bson.M{"_id": dictionary.ID}, dictionary); err != nil {
+				return err
+			}
+		}
+
+		if dictionary.DictionaryMetadata.Status == "default" {
+			defaultDictionaryId = dictionary.ID
+		}
+		names = append(names, dictionary.DictionaryMetadata.Name)
+	}
+
+	// Dictionaries removed from disc
+	_, err = libraryCollection.DeleteMany(
+		context.TODO(),
+		bson.M{"dictionaryMetadata.status": bson.M{"$in": []string{"library", "default"}}, "dictionaryMetadata.name": bson.M{"$nin": names}},
+	)
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -372,7 +978,7 @@ var dictStatuses = map[string]string{
 	"public":  "public",  //Pushed by administrator or copied by adminstrator from another one
 	"default": "public",  //Default dict for new users, from default library
 	"private": "private", //Pused by user, but isn't used now
-	"current": "private", //Picked or pushed by user and is used now
+	"current": "private", //Picked or pushed by user and is learned now, kept in user dictionaries
 }
 
 func loadAllPublicDictionaryFromBase() (dictionaries []Dictionary, err error) {
@@ -432,6 +1038,7 @@ func loadDictionaryFromBase(dictionaryId *primitive.ObjectID) (dictionary Dictio
 func dumpDictionaryToBase(dictionary *Dictionary) (*primitive.ObjectID, error) {
 
 	dictionary.ID = primitive.NewObjectID()
+	dictionary.FactSet.assignIDs()
 
 	_, err := libraryCollection.InsertOne(
 		context.TODO(),
@@ -444,25 +1051,6 @@ func dumpDictionaryToBase(dictionary *Dictionary) (*primitive.ObjectID, error) {
 	return &dictionary.ID, nil
 }
 
-func copyDictionaryInBase(sourceId *primitive.ObjectID) (destinationId *primitive.ObjectID, err error) {
-
-	dictionary, err := loadDictionaryFromBase(sourceId)
-	if err != nil {
-		return nil, err
-	}
-
-	//dictionary.DictionaryMetadata.Status = "current"
-	//dictionary.DictionaryMetadata.Name = ""
-	dictionary.DictionaryMetadata.FilePath = dictionary.ID.Hex()
-
-	destinationId, err = dumpDictionaryToBase(&dictionary)
-	if err != nil {
-		return nil, err
-	}
-
-	return destinationId, nil
-}
-
 func setDictionaryMetaInBase(dictionaryId *primitive.ObjectID, metadata DictionaryMetadata) (err error) {
 
 	if metadata.Name != "" {
@@ -496,50 +1084,197 @@ func setDictionaryMetaInBase(dictionaryId *primitive.ObjectID, metadata Dictiona
 	return nil
 }
 
-func organizePrivateUserDictionariesInBase(userId int) (err error) {
+func deleteDictionaryFromBase(dictionaryId *primitive.ObjectID) error {
 
-	result, err := libraryCollection.UpdateMany(
+	if _, err := libraryCollection.DeleteOne(context.TODO(), bson.M{"_id": dictionaryId}); err != nil {
+		return err
+	}
+
+	if _, err := progressCollection.DeleteMany(context.TODO(), bson.M{"dictionaryId": dictionaryId}); err != nil {
+		return err
+	}
+
+	_, err := usersCollection.UpdateMany(
 		context.TODO(),
-		bson.M{"dictionaryMetadata.ownerId": userId},
-		bson.M{"$set": bson.M{"dictionaryMetadata.status": "private"}},
+		bson.M{"dictionaries.dictionaryId": dictionaryId},
+		bson.M{"$pull": bson.M{"dictionaries": bson.M{"dictionaryId": dictionaryId}}},
 	)
 	if err != nil {
 		return err
 	}
-	log.Printf("result.MatchedCount: %v\n", result.MatchedCount)
-	log.Printf("result.ModifiedCount: %v\n", result.ModifiedCount)
-	log.Printf("result.UpsertedCount: %v\n", result.UpsertedCount)
-	if result.MatchedCount > 3 || result.ModifiedCount > 3 || result.UpsertedCount > 3 {
 
-		oldestDictCursor, err := libraryCollection.Aggregate(
-			context.TODO(),
-			[]interface{}{
-				bson.M{"$match": bson.M{"dictionaryMetadata.ownerId": userId}},
-				bson.M{"$group": bson.M{
-					"_id":  "$dictionaryMetadata.ownerId",
-					"date": bson.M{"$min": "$dictionaryMetadata.date"}}}},
-		)
-		if err != nil {
-			log.Printf("err: %v\n", err)
-		}
+	return nil
+}
 
-		type oldestDict struct {
-			Id   int       `bson:"_id"`
-			Date time.Time `bson:"date"`
-		}
-		var oldDict oldestDict
-		for oldestDictCursor.Next(context.TODO()) {
-			oldestDictCursor.Decode(&oldDict)
-
-			_, err = libraryCollection.DeleteOne(
-				context.TODO(),
-				bson.M{"dictionaryMetadata.ownerId": oldDict.Id, "dictionaryMetadata.date": oldDict.Date},
-			)
-			if err != nil {
-				log.Printf("err: %v\n", err)
-			}
-		}
+// Quiz in progress, it is dumped after each answer to survive restarts
+type QuizSession struct {
+	UserID         int       `bson:"userId"`
+	FactSet        FactSet   `bson:"factSet"`
+	Index          int       `bson:"index"`
+	QuizID         string    `bson:"quizId"`
+	CorrectOption  int       `bson:"correctOption"`
+	Practice       bool      `bson:"practice"`
+	Typing         bool      `bson:"typing"`
+	AnswerOptions  int       `bson:"answerOptions"`
+	StopwatchStart time.Time `bson:"stopwatchStart"`
+	Right          int       `bson:"right"`
+	Wrong          int       `bson:"wrong"`
+	Date           time.Time `bson:"date"`
+}
+
+// User has one quiz in progress
+func createSessionsIndex() error {
+
+	_, err := sessionsCollection.Indexes().CreateOne(
+		context.TODO(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+
+	return err
+}
+
+func dumpQuizSessionToBase(quizSession *QuizSession) error {
+
+	_, err := sessionsCollection.ReplaceOne(
+		context.TODO(),
+		bson.M{"userId": quizSession.UserID},
+		quizSession,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
+
+func deleteQuizSessionFromBase(userId int) error {
+
+	_, err := sessionsCollection.DeleteOne(context.TODO(), bson.M{"userId": userId})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Session of user in base, Version is changed by every update
+type StoredSession struct {
+	UserID         int           `bson:"userId"`
+	Version        int64         `bson:"version"`
+	ForReview      FactSet       `bson:"forReview"`
+	Index          int           `bson:"index"`
+	QuizID         string        `bson:"quizId"`
+	CorrectOption  int           `bson:"correctOption"`
+	Options        []string      `bson:"options"`
+	Answer         string        `bson:"answer"`
+	Cards          FactSet       `bson:"cards"`
+	Practice       bool          `bson:"practice"`
+	Typing         bool          `bson:"typing"`
+	AnswerOptions  int           `bson:"answerOptions"`
+	StopwatchStart time.Time     `bson:"stopwatchStart"`
+	StopwatchMark  time.Duration `bson:"stopwatchMark"`
+	Quality        int           `bson:"quality"`
+	Right          int           `bson:"right"`
+	Wrong          int           `bson:"wrong"`
+	Membership     string        `bson:"membership"`
+	// IANA name, empty until location is loaded
+	Timezone     string       `bson:"timezone"`
+	Conversation Conversation `bson:"conversation"`
+}
+
+func createUserSessionsIndex() error {
+
+	_, err := userSessionsCollection.Indexes().CreateOne(
+		context.TODO(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "userId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+
+	return err
+}
+
+func loadSessionFromBase(userId int) (session StoredSession, err error) {
+
+	err = userSessionsCollection.FindOne(context.TODO(), bson.M{"userId": userId}).Decode(&session)
+
+	return session, err
+}
+
+func loadAllSessionsFromBase() (sessions []StoredSession, err error) {
+
+	cursor, err := userSessionsCollection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cursor.All(context.TODO(), &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Session is written only if it wasn't changed since it was loaded with version, false is returned otherwise.
+// Version 0 is for session which isn't in base yet. Cards are big and rarely changed, so they are written on demand
+func dumpSessionToBase(session StoredSession, version int64, withCards bool) (bool, error) {
+
+	session.Version = version + 1
+
+	if version == 0 {
+		_, err := userSessionsCollection.InsertOne(context.TODO(), session)
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	data, err := bson.Marshal(session)
+	if err != nil {
+		return false, err
+	}
+	var fields bson.M
+	if err = bson.Unmarshal(data, &fields); err != nil {
+		return false, err
+	}
+	if !withCards {
+		delete(fields, "cards")
+	}
+
+	result, err := userSessionsCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"userId": session.UserID, "version": version},
+		bson.M{"$set": fields},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+func deleteSessionFromBase(userId int) error {
+
+	_, err := userSessionsCollection.DeleteOne(context.TODO(), bson.M{"userId": userId})
+
+	return err
+}
+
+func loadAllQuizSessionsFromBase() (quizSessions []QuizSession, err error) {
+
+	cursor, err := sessionsCollection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cursor.All(context.TODO(), &quizSessions); err != nil {
+		return nil, err
+	}
+
+	return quizSessions, nil
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Actions of "My dictionaries" screen, callback data is like "dictUse|<dictionary id>"
+const (
+	showDictionaryAction   = "dict"
+	useDictionaryAction    = "dictUse"
+	activateAction         = "dictOn"
+	deactivateAction       = "dictOff"
+	limitAction            = "dictLimit"
+	reverseAction          = "dictReverse"
+	pullAction             = "dictPull"
+	schedulerAction        = "dictScheduler"
+	renameDictionaryAction = "dictRename"
+	deleteDictionaryAction = "dictDelete"
+	confirmDeleteAction    = "dictDeleteYes"
+)
+
+var maxDictionaryNameLength = 40
+
+// Limit button switches to the next one, 0 is no limit
+var dictionaryLimits = []int{0, 10, 20, 50}
+
+func nextDictionaryLimit(limit int) int {
+
+	for i, l := range dictionaryLimits {
+		if l == limit && i+1 < len(dictionaryLimits) {
+			return dictionaryLimits[i+1]
+		}
+	}
+
+	return dictionaryLimits[0]
+}
+
+// Scheduler button switches to the next one, empty one is scheduler of user
+func nextDictionaryScheduler(scheduler string) string {
+
+	options := append([]string{""}, schedulerNames...)
+	for i, name := range options {
+		if name == scheduler && i+1 < len(options) {
+			return options[i+1]
+		}
+	}
+
+	return options[0]
+}
+
+func schedulerText(scheduler string) string {
+
+	if scheduler == "" {
+		return "as in settings"
+	}
+
+	return schedulerTitles[scheduler]
+}
+
+func limitText(limit int) string {
+
+	if limit == 0 {
+		return "all"
+	}
+
+	return strconv.Itoa(limit)
+}
+
+// Quota above it is surely a typo
+var maxDictionaryQuota = 100
+
+var quotaReachedMessage = "You have no room for one more dictionary. Delete one in Settings > My dictionaries."
+
+func dictionaryCallback(action string, dictionaryId primitive.ObjectID) string {
+	return action + "|" + dictionaryId.Hex()
+}
+
+func parseDictionaryCallback(data string) (action string, dictionaryId primitive.ObjectID, ok bool) {
+
+	parts := strings.Split(data, "|")
+	if len(parts) != 2 {
+		return "", dictionaryId, false
+	}
+
+	switch parts[0] {
+	case showDictionaryAction, useDictionaryAction, activateAction, deactivateAction, limitAction, schedulerAction,
+		reverseAction, pullAction, renameDictionaryAction, deleteDictionaryAction, confirmDeleteAction:
+	default:
+		return "", dictionaryId, false
+	}
+
+	dictionaryId, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return "", dictionaryId, false
+	}
+
+	return parts[0], dictionaryId, true
+}
+
+func userDictionaryName(userDictionary *UserDictionary, dictionary *Dictionary) string {
+
+	if userDictionary != nil && userDictionary.Name != "" {
+		return userDictionary.Name
+	}
+
+	return dictionary.DictionaryMetadata.Name
+}
+
+// Default dictionary is learned without pointer in user list until user picks one,
+// it is shown in the list then as current one
+func userDictionaryOrDefault(userDictionaries []UserDictionary, dictionaryId primitive.ObjectID) *UserDictionary {
+
+	if userDictionary := findUserDictionary(userDictionaries, dictionaryId); userDictionary != nil {
+		return userDictionary
+	}
+	if dictionaryId == defaultDictionaryId && !hasCurrentDictionary(userDictionaries) {
+		return &UserDictionary{DictionaryID: defaultDictionaryId, Status: "current"}
+	}
+
+	return nil
+}
+
+func onOffText(on bool) string {
+
+	if on {
+		return "on"
+	}
+
+	return "off"
+}
+
+// Own dictionaries are deleted from library, public ones are only removed from user list
+func ownDictionary(userId int, dictionary *Dictionary) bool {
+	return dictionary.DictionaryMetadata.OwnerID == userId && dictStatuses[dictionary.DictionaryMetadata.Status] != "public"
+}
+
+func myDictionariesKeyboard(userId int) (string, tgbotapi.InlineKeyboardMarkup, error) {
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+
+	userDictionaries, err := loadUserDictionariesFromBase(userId)
+	if err != nil {
+		return "", keyboard, err
+	}
+	quota, err := loadDictionaryQuotaFromBase(userId)
+	if err != nil {
+		return "", keyboard, err
+	}
+
+	text := fmt.Sprintf("My dictionaries, %v of %v:", len(userDictionaries), quota)
+	if len(userDictionaries) == 0 {
+		text = "You have no dictionaries yet, default one is used. Pick or push your dictionary in Settings."
+	}
+
+	if !hasCurrentDictionary(userDictionaries) {
+		dictionary, err := loadDictionaryFromBase(&defaultDictionaryId)
+		if err != nil {
+			return "", keyboard, err
+		}
+		if !dictionary.ID.IsZero() && findUserDictionary(userDictionaries, defaultDictionaryId) == nil {
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Default: "+dictionary.DictionaryMetadata.Name, dictionaryCallback(showDictionaryAction, dictionary.ID)),
+			))
+		}
+	}
+
+	for i := range userDictionaries {
+		userDictionary := &userDictionaries[i]
+		dictionary, err := loadDictionaryFromBase(&userDictionary.DictionaryID)
+		if err != nil {
+			return "", keyboard, err
+		}
+		if dictionary.ID.IsZero() {
+			continue
+		}
+
+		btnText := "Name: " + userDictionaryName(userDictionary, &dictionary) +
+			"\nStatus:" + userDictionary.Status
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(btnText, dictionaryCallback(showDictionaryAction, dictionary.ID)),
+		))
+	}
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", "backToSettings"),
+	))
+
+	return text, keyboard, nil
+}
+
+func showMyDictionaries(bot *tgbotapi.BotAPI, userId int) error {
+
+	text, keyboard, err := myDictionariesKeyboard(userId)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(int64(userId), text)
+	msg.ReplyMarkup = keyboard
+	if _, err = bot.Send(msg); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func dictionaryKeyboard(userId int, dictionaryId primitive.ObjectID) (string, tgbotapi.InlineKeyboardMarkup, error) {
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+
+	dictionary, err := loadDictionaryFromBase(&dictionaryId)
+	if err != nil {
+		return "", keyboard, err
+	}
+	userDictionaries, err := loadUserDictionariesFromBase(userId)
+	if err != nil {
+		return "", keyboard, err
+	}
+	userDictionary := userDictionaryOrDefault(userDictionaries, dictionaryId)
+	if dictionary.ID.IsZero() || userDictionary == nil {
+		return "", keyboard, fmt.Errorf("dictionary %v isn't in list of user %v", dictionaryId.Hex(), userId)
+	}
+
+	text := "Name: " + userDictionaryName(userDictionary, &dictionary) +
+		"\nStatus: " + userDictionary.Status +
+		"\nCards: " + strconv.Itoa(len(dictionary.FactSet)) +
+		"\nCards for review per day: " + limitText(userDictionary.Limit) +
+		"\nReverse cards: " + onOffText(userDictionary.Reverse) +
+		"\nScheduling algorithm: " + schedulerText(userDictionary.Scheduler)
+	if !userDictionary.Date.IsZero() {
+		text += "\nAdded: " + userDictionary.Date.Local().Format("2006-January-02 15:04:05")
+	}
+
+	// Several dictionaries can be learned together
+	if userDictionary.Status != "current" {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Learn it too", dictionaryCallback(activateAction, dictionaryId)),
+			tgbotapi.NewInlineKeyboardButtonData("Learn only it", dictionaryCallback(useDictionaryAction, dictionaryId)),
+		))
+	} else {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Stop learning", dictionaryCallback(deactivateAction, dictionaryId)),
+			tgbotapi.NewInlineKeyboardButtonData("Learn only it", dictionaryCallback(useDictionaryAction, dictionaryId)),
+		))
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Cards per day: "+limitText(userDictionary.Limit), dictionaryCallback(limitAction, dictionaryId)),
+	))
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Algorithm: "+schedulerText(userDictionary.Scheduler), dictionaryCallback(schedulerAction, dictionaryId)),
+	))
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Reverse cards: "+onOffText(userDictionary.Reverse), dictionaryCallback(reverseAction, dictionaryId)),
+		tgbotapi.NewInlineKeyboardButtonData("Pull", dictionaryCallback(pullAction, dictionaryId)),
+	))
+
+	deleteText := "Remove from my dictionaries"
+	if ownDictionary(userId, &dictionary) {
+		deleteText = "Delete"
+	}
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Rename", dictionaryCallback(renameDictionaryAction, dictionaryId)),
+			tgbotapi.NewInlineKeyboardButtonData(deleteText, dictionaryCallback(deleteDictionaryAction, dictionaryId)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("<< Back", "myDicts"),
+		),
+	)
+
+	return text, keyboard, nil
+}
+
+func confirmDeleteKeyboard(userId int, dictionaryId primitive.ObjectID) (string, tgbotapi.InlineKeyboardMarkup, error) {
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+
+	dictionary, err := loadDictionaryFromBase(&dictionaryId)
+	if err != nil {
+		return "", keyboard, err
+	}
+	userDictionaries, err := loadUserDictionariesFromBase(userId)
+	if err != nil {
+		return "", keyboard, err
+	}
+	name := userDictionaryName(findUserDictionary(userDictionaries, dictionaryId), &dictionary)
+
+	text := "Remove " + name + " from your dictionaries? It stays in library and your progress is kept."
+	if ownDictionary(userId, &dictionary) {
+		text = "Delete " + name + "? Dictionary and your progress on it will be lost."
+	}
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Yes", dictionaryCallback(confirmDeleteAction, dictionaryId)),
+		tgbotapi.NewInlineKeyboardButtonData("No", dictionaryCallback(showDictionaryAction, dictionaryId)),
+	))
+
+	return text, keyboard, nil
+}
+
+// Nothing is deleted without user asking for it
+func deleteUserDictionary(userId int, dictionaryId primitive.ObjectID) error {
+
+	dictionary, err := loadDictionaryFromBase(&dictionaryId)
+	if err != nil {
+		return err
+	}
+
+	if !dictionary.ID.IsZero() && ownDictionary(userId, &dictionary) {
+		return deleteDictionaryFromBase(&dictionaryId)
+	}
+
+	return removeUserDictionaryInBase(userId, dictionaryId)
+}
+
+// Admin command "/setquota <user id> <quota>", quota 0 brings back default one
+func setDictionaryQuota(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+
+	userId := message.From.ID
+	if !isAdmin(userId) {
+		showMessage(bot, userId, "Only admins of the group can set quotas.")
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) != 2 {
+		showMessage(bot, userId, "Use /setquota <user id> <quota>, quota 0 is default one.")
+		return
+	}
+	targetId, err := strconv.Atoi(args[0])
+	if err != nil {
+		showMessage(bot, userId, "Can't read user id "+args[0]+".")
+		return
+	}
+	quota, err := strconv.Atoi(args[1])
+	if err != nil || quota < 0 || quota > maxDictionaryQuota {
+		showMessage(bot, userId, fmt.Sprintf("Quota should be from 0 to %v.", maxDictionaryQuota))
+		return
+	}
+
+	if err = dumpDictionaryQuotaToBase(targetId, quota); err == mongo.ErrNoDocuments {
+		showMessage(bot, userId, "User "+args[0]+" isn't found.")
+		return
+	} else if err != nil {
+		log.Printf("err: %v\n", err)
+		showMessage(bot, userId, "Can't set quota, try again later.")
+		return
+	}
+
+	if quota == 0 {
+		quota = defaultDictionaryQuota
+	}
+	showMessage(bot, userId, fmt.Sprintf("Quota of user %v is %v dictionaries.", targetId, quota))
+}
+
+func askForDictionaryName(bot *tgbotapi.BotAPI, userId int, dictionaryId primitive.ObjectID) {
+	sessions.Update(userId, func(session *Session) {
+		session.Conversation = Conversation{State: AwaitingDictionaryName, Since: time.Now(), DictionaryID: dictionaryId}
+	})
+	showMessage(bot, userId, "Waiting for new name of dictionary. Use /cancel to stop waiting.")
+}
+
+func renameDictionary(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+
+	userId := message.From.ID
+	name := strings.TrimSpace(message.Text)
+	if name == "" || len([]rune(name)) > maxDictionaryNameLength {
+		showMessage(bot, userId, fmt.Sprintf("Name should be 1 to %v characters long, try again.", maxDictionaryNameLength))
+		return
+	}
+
+	dictionaryId := sessions.Get(userId).Conversation.DictionaryID
+	if err := renameUserDictionaryInBase(userId, dictionaryId, name); err != nil {
+		log.Printf("err: %v\n", err)
+		return
+	}
+
+	setState(userId, Idle)
+	showMessage(bot, userId, "Dictionary is renamed.")
+	if err := showMyDictionaries(bot, userId); err != nil {
+		log.Printf("err: %v\n", err)
+	}
+}
+
+func editDictionaryScreen(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	msg := tgbotapi.NewEditMessageText(callbackQuery.Message.Chat.ID, callbackQuery.Message.MessageID, text)
+	kbrd := tgbotapi.NewEditMessageReplyMarkup(callbackQuery.Message.Chat.ID, callbackQuery.Message.MessageID, keyboard)
+	bot.Send(msg)
+	bot.Send(kbrd)
+}
+
+func handleDictionaryCallback(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery, action string, dictionaryId primitive.ObjectID) error {
+
+	userId := callbackQuery.From.ID
+
+	switch action {
+	case useDictionaryAction:
+		if err := pickDictionary(userId, dictionaryId); err != nil {
+			return err
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackQuery.ID, "Dictionary is current now."))
+
+	case activateAction, deactivateAction:
+		if err := checkDictionaryAccess(userId, dictionaryId); err != nil {
+			return err
+		}
+		if err := activateUserDictionaryInBase(userId, dictionaryId, action == activateAction); err != nil {
+			return err
+		}
+
+	case limitAction, schedulerAction, reverseAction:
+		userDictionaries, err := loadUserDictionariesFromBase(userId)
+		if err != nil {
+			return err
+		}
+		userDictionary := userDictionaryOrDefault(userDictionaries, dictionaryId)
+		if userDictionary == nil {
+			return fmt.Errorf("dictionary %v isn't in list of user %v", dictionaryId.Hex(), userId)
+		}
+		// Default dictionary gets pointer when user changes something in it
+		if findUserDictionary(userDictionaries, dictionaryId) == nil {
+			if err = activateUserDictionaryInBase(userId, dictionaryId, true); err != nil {
+				return err
+			}
+		}
+		switch action {
+		case limitAction:
+			err = setUserDictionaryLimitInBase(userId, dictionaryId, nextDictionaryLimit(userDictionary.Limit))
+		case schedulerAction:
+			err = setUserDictionarySchedulerInBase(userId, dictionaryId, nextDictionaryScheduler(userDictionary.Scheduler))
+		case reverseAction:
+			err = setUserDictionaryReverseInBase(userId, dictionaryId, !userDictionary.Reverse)
+		}
+		if err != nil {
+			return err
+		}
+
+	case pullAction:
+		return pullDictionaryFromBase(bot, userId, dictionaryId)
+
+	case renameDictionaryAction:
+		askForDictionaryName(bot, userId, dictionaryId)
+		return nil
+
+	case deleteDictionaryAction:
+		text, keyboard, err := confirmDeleteKeyboard(userId, dictionaryId)
+		if err != nil {
+			return err
+		}
+		editDictionaryScreen(bot, callbackQuery, text, keyboard)
+		return nil
+
+	case confirmDeleteAction:
+		if err := deleteUserDictionary(userId, dictionaryId); err != nil {
+			return err
+		}
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackQuery.ID, "Dictionary is deleted."))
+
+		text, keyboard, err := myDictionariesKeyboard(userId)
+		if err != nil {
+			return err
+		}
+		editDictionaryScreen(bot, callbackQuery, text, keyboard)
+		return nil
+	}
+
+	text, keyboard, err := dictionaryKeyboard(userId, dictionaryId)
+	if err != nil {
+		return err
+	}
+	editDictionaryScreen(bot, callbackQuery, text, keyboard)
+
+	return nil
+}
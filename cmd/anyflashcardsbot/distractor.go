@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Rough guess by word endings, good enough to keep verbs with verbs
+var partOfSpeechEndings = []struct {
+	partOfSpeech string
+	endings      []string
+}{
+	{"verb", []string{"ть", "ться", "ти", "чь"}},
+	{"adjective", []string{"ый", "ий", "ой", "ая", "яя", "ое", "ее", "ful", "ous", "ive", "able", "ible"}},
+	{"adverb", []string{"ly"}},
+	{"noun", []string{"tion", "ment", "ness", "ость", "ние", "ство"}},
+}
+
+func guessPartOfSpeech(answer string) string {
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	words := strings.Fields(answer)
+	if len(words) == 0 {
+		return ""
+	}
+
+	// English verbs are often written with "to"
+	if words[0] == "to" && len(words) > 1 {
+		return "verb"
+	}
+
+	// Phrases like "recognize sb/sth" are decided by the first word
+	for _, guess := range partOfSpeechEndings {
+		for _, ending := range guess.endings {
+			if strings.HasSuffix(words[0], ending) {
+				return guess.partOfSpeech
+			}
+		}
+	}
+
+	return ""
+}
+
+func guessScript(answer string) string {
+
+	for _, r := range answer {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return "cyrillic"
+		}
+		if unicode.Is(unicode.Latin, r) {
+			return "latin"
+		}
+	}
+
+	return ""
+}
+
+// Lower score means candidate looks more like correct answer
+func distractorScore(correct, candidate string) int {
+
+	score := 0
+
+	if guessScript(correct) != guessScript(candidate) {
+		score += 100
+	}
+	if guessPartOfSpeech(correct) != guessPartOfSpeech(candidate) {
+		score += 10
+	}
+
+	lengthDifference := len([]rune(correct)) - len([]rune(candidate))
+	if lengthDifference < 0 {
+		lengthDifference = -lengthDifference
+	}
+	score += lengthDifference
+
+	wordsDifference := len(strings.Fields(correct)) - len(strings.Fields(candidate))
+	if wordsDifference < 0 {
+		wordsDifference = -wordsDifference
+	}
+	score += 3 * wordsDifference
+
+	// Close spelling makes answer harder to guess
+	score += editDistance(normalizeAnswer(correct), normalizeAnswer(candidate)) / 2
+
+	return score
+}
+
+// Pick wrong answers for multiple choice, candidates are usually whole dictionary
+func pickDistractors(correct Fact, candidates FactSet, count int) []string {
+
+	type scored struct {
+		answer string
+		score  int
+	}
+
+	// Never offer correct answer text twice, nor the same distractor twice
+	seen := map[string]bool{normalizeAnswer(correct.Answer): true}
+	var pool []scored
+	for _, candidate := range candidates {
+		if candidate.Reversed != correct.Reversed {
+			continue
+		}
+		normalized := normalizeAnswer(candidate.Answer)
+		if normalized == "" || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		score := distractorScore(correct.Answer, candidate.Answer)
+		// Quiz can mix several dictionaries, answers from the same one fit better
+		if candidate.DictionaryID != correct.DictionaryID {
+			score += 50
+		}
+		pool = append(pool, scored{candidate.Answer, score})
+	}
+
+	// Shuffle before stable sort, so equal scores come in random order
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].score < pool[j].score })
+
+	// Choose randomly among the best ones, so the same card doesn't get the same options
+	best := count * 3
+	if best > len(pool) {
+		best = len(pool)
+	}
+	pool = pool[:best]
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	var distractors []string
+	for i := 0; i < count && i < len(pool); i++ {
+		distractors = append(distractors, pool[i].answer)
+	}
+
+	return distractors
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -44,6 +45,7 @@ func downloadFile(URL, filePath string) error {
 	return nil
 }
 
+// Facts get IDs when dictionary is dumped, library dictionaries keep IDs they had, see updateDefaultLibrary
 func readDictionaryFromDisc(csvPath string) (dictionary Dictionary, err error) {
 
 	f, err := os.Open(csvPath)
@@ -53,6 +55,8 @@ func readDictionaryFromDisc(csvPath string) (dictionary Dictionary, err error) {
 	defer f.Close()
 
 	var smFactSet supermemo.FactSet
+	// Progress of reverse cards by index of fact, see writeFactsToCsv
+	var reverses []FactMetadata
 	csvr := csv.NewReader(f)
 	csvr.FieldsPerRecord = -1
 	for {
@@ -63,6 +67,16 @@ func readDictionaryFromDisc(csvPath string) (dictionary Dictionary, err error) {
 			}
 			return dictionary, err
 		}
+
+		var reverse FactMetadata
+		if len(record) == 10 {
+			if reverse, err = parseFactMetadata(record[6:]); err != nil {
+				return dictionary, err
+			}
+			record = record[:6]
+		}
+		reverses = append(reverses, reverse)
+
 		smFactSet, err = addFact(smFactSet, record)
 		if err != nil {
 			return dictionary, err
@@ -70,6 +84,9 @@ func readDictionaryFromDisc(csvPath string) (dictionary Dictionary, err error) {
 	}
 
 	factSet := convertToFactSet(&smFactSet)
+	for i := range factSet {
+		factSet[i].Reverse = reverses[i]
+	}
 
 	file, err := os.Stat(csvPath)
 	if err != nil {
@@ -122,23 +139,82 @@ func writeFactsToDisc(csvPath string, factSet FactSet) error {
 		return err
 	}
 
-	csvw := csv.NewWriter(file)
+	if err = writeFactsToCsv(file, factSet); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Six-column format (question, answer, ef, n, interval, intervalFrom) is readable by addFact,
+// facts with reviewed reverse card have four more columns of the same metadata for it.
+// Facts which were never reviewed are written in two columns, like in source dictionaries
+func writeFactsToCsv(w io.Writer, factSet FactSet) error {
+
+	csvw := csv.NewWriter(w)
 
 	for _, fact := range factSet {
-		ef := fmt.Sprintf("%0.6f", fact.FactMetadata.Ef)
-		n := fmt.Sprintf("%d", fact.FactMetadata.N)
-		interval := fmt.Sprintf("%d", fact.FactMetadata.Interval)
+		record := []string{fact.Question, fact.Answer}
+		if hasProgress(fact.FactMetadata) || hasProgress(fact.Reverse) {
+			record = append(record, factMetadataRecord(fact.FactMetadata)...)
+		}
+		if hasProgress(fact.Reverse) {
+			record = append(record, factMetadataRecord(fact.Reverse)...)
+		}
 
-		csvw.Write([]string{fact.Question, fact.Answer, ef, n, interval, fact.FactMetadata.IntervalFrom})
+		csvw.Write(record)
 	}
 
 	csvw.Flush()
 
-	if err = file.Close(); err != nil {
-		return err
+	return csvw.Error()
+}
+
+func factMetadataRecord(metadata FactMetadata) []string {
+	return []string{
+		fmt.Sprintf("%0.6f", metadata.Ef),
+		fmt.Sprintf("%d", metadata.N),
+		fmt.Sprintf("%d", metadata.Interval),
+		metadata.IntervalFrom,
 	}
+}
 
-	return nil
+// Read ef, n, interval and intervalFrom, they are checked the same way as in addFact.
+// Card without intervalFrom was never reviewed, it has no metadata
+func parseFactMetadata(record []string) (metadata FactMetadata, err error) {
+
+	if len(record) != 4 {
+		return metadata, errors.New("invalid record format")
+	}
+	if record[3] == "" {
+		return metadata, nil
+	}
+
+	ef, err := strconv.ParseFloat(record[0], 64)
+	if err != nil {
+		return metadata, err
+	}
+	n, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return metadata, err
+	}
+	interval, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return metadata, err
+	}
+	smFact, err := supermemo.LoadFact("", "", ef, int(n), int(interval), record[3])
+	if err != nil {
+		return metadata, err
+	}
+
+	_, _, metadata.Ef, metadata.N, metadata.Interval, metadata.IntervalFrom = smFact.Dump()
+
+	return metadata, nil
 }
 
 /*
@@ -190,6 +266,11 @@ func addFact(fs supermemo.FactSet, record []string) (supermemo.FactSet, error) {
 			return nil, err
 		}
 		intervalFrom := record[5]
+		// Card was never reviewed, e.g. only its reverse card has progress
+		if intervalFrom == "" {
+			fact = supermemo.NewFact(q, a)
+			break
+		}
 		fact, err = supermemo.LoadFact(q, a, ef, int(n), int(interval), intervalFrom)
 		if err != nil {
 			return nil, err
@@ -227,18 +308,25 @@ func pushDictionaryToBase(bot *tgbotapi.BotAPI, update *tgbotapi.Update) (_id *p
 
 			dictionary, err := readDictionaryFromDisc(csvDictionaryPath)
 			if err != nil {
+				showMessage(bot, update.Message.From.ID, "Can't read your file: "+err.Error()+". Fix it and send again or use /cancel.")
 				return nil, err
 			}
 
 			dictionary.DictionaryMetadata.OwnerID = update.Message.From.ID
+			dictionary.DictionaryMetadata.Status = "private"
 
 			_id, err = dumpDictionaryToBase(&dictionary)
 			if err != nil {
 				return nil, err
 			}
 
-			// Reset waiting bool
-			waitingForDictionaryFile = false
+			// Pulled dictionary carries progress, it is restored
+			if err = dumpImportedProgressToBase(update.Message.From.ID, &dictionary); err != nil {
+				return nil, err
+			}
+
+			// Dictionary is received, stop waiting
+			setState(update.Message.From.ID, Idle)
 
 			showMessage(bot, update.Message.From.ID, "Dictionary pushed.")
 			showMainMeny(bot, update.Message.From.ID)
@@ -254,3 +342,56 @@ func pushDictionaryToBase(bot *tgbotapi.BotAPI, update *tgbotapi.Update) (_id *p
 
 	return _id, nil
 }
+
+// Only one dictionary can be pulled, others are pulled from Settings > My dictionaries
+func pullActiveDictionary(bot *tgbotapi.BotAPI, userId int) error {
+
+	dictionaries, err := loadActiveDictionariesFromBase(userId)
+	if err != nil {
+		showMessage(bot, userId, "You have no dictionary for pulling. Pick or push one in /settings.")
+		return err
+	}
+
+	if len(dictionaries) > 1 {
+		showMessage(bot, userId, "You learn several dictionaries, pull one of them here.")
+		return showMyDictionaries(bot, userId)
+	}
+
+	return pullDictionaryFromBase(bot, userId, dictionaries[0].ID)
+}
+
+func pullDictionaryFromBase(bot *tgbotapi.BotAPI, userId int, dictionaryId primitive.ObjectID) error {
+
+	userDictionaries, err := loadUserDictionariesFromBase(userId)
+	if err != nil {
+		return err
+	}
+	userDictionary := userDictionaryOrDefault(userDictionaries, dictionaryId)
+	if userDictionary == nil {
+		return fmt.Errorf("dictionary %v isn't in list of user %v", dictionaryId.Hex(), userId)
+	}
+
+	dictionary, err := loadUserDictionaryFromBase(userId, userDictionary)
+	if err != nil {
+		showMessage(bot, userId, "You have no dictionary for pulling. Pick or push one in /settings.")
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if err = writeFactsToCsv(&buffer, dictionary.FactSet); err != nil {
+		return err
+	}
+
+	fileName := dictionary.DictionaryMetadata.Name
+	if fileName == "" {
+		fileName = "dictionary.csv"
+	}
+
+	document := tgbotapi.NewDocumentUpload(int64(userId), tgbotapi.FileBytes{Name: fileName, Bytes: buffer.Bytes()})
+	document.Caption = "Your dictionary with progress. Push it back any time with /pushdict."
+	if _, err = bot.Send(document); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCsvRoundTrip(t *testing.T) {
+
+	reviewed := FactMetadata{Ef: 2.36, N: 2, Interval: 6, IntervalFrom: "2021-10-01"}
+	reverse := FactMetadata{Ef: 2.6, N: 1, Interval: 1, IntervalFrom: "2021-10-03"}
+	factSet := FactSet{
+		{Question: "new", Answer: "card"},
+		{Question: "reviewed", Answer: "card", FactMetadata: reviewed},
+		{Question: "reverse", Answer: "only", Reverse: reverse},
+		{Question: "both", Answer: "sides", FactMetadata: reviewed, Reverse: reverse},
+		{Question: "with, comma", Answer: "card"},
+	}
+
+	path := filepath.Join(t.TempDir(), "pulled.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeFactsToCsv(f, factSet); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Never reviewed cards have no metadata columns
+	content, _ := os.ReadFile(path)
+	if lines := strings.Split(string(content), "\n"); lines[0] != "new,card" {
+		t.Errorf("new card is written as %q", lines[0])
+	}
+
+	dictionary, err := readDictionaryFromDisc(path)
+	if err != nil {
+		t.Fatalf("pulled dictionary isn't read back: %v", err)
+	}
+	if len(dictionary.FactSet) != len(factSet) {
+		t.Fatalf("got %d facts, want %d", len(dictionary.FactSet), len(factSet))
+	}
+
+	for i, want := range factSet {
+		got := dictionary.FactSet[i]
+		if got.Question != want.Question || got.Answer != want.Answer {
+			t.Errorf("fact %d: got %q - %q, want %q - %q", i, got.Question, got.Answer, want.Question, want.Answer)
+		}
+		if hasProgress(got.FactMetadata) != hasProgress(want.FactMetadata) {
+			t.Errorf("%q: progress is %+v, want %+v", want.Question, got.FactMetadata, want.FactMetadata)
+		} else if hasProgress(want.FactMetadata) && got.FactMetadata != want.FactMetadata {
+			t.Errorf("%q: progress is %+v, want %+v", want.Question, got.FactMetadata, want.FactMetadata)
+		}
+		if got.Reverse != want.Reverse {
+			t.Errorf("%q: reverse progress is %+v, want %+v", want.Question, got.Reverse, want.Reverse)
+		}
+	}
+}
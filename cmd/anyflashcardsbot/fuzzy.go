@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Cyrillic letters which look the same as latin ones in lower case, both sides are folded to latin.
+// Letters which look alike only in upper case, like н and h, are different answers
+var lookalikes = strings.NewReplacer(
+	"а", "a",
+	"е", "e",
+	"о", "o",
+	"р", "p",
+	"с", "c",
+	"х", "x",
+)
+
+func normalizeAnswer(answer string) string {
+
+	answer = strings.ToLower(answer)
+
+	// Drop diacritics, café and cafe are the same answer
+	stripDiacritics := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if stripped, _, err := transform.String(stripDiacritics, answer); err == nil {
+		answer = stripped
+	}
+	answer = lookalikes.Replace(answer)
+
+	// Punctuation separates words like spaces do
+	answer = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return ' '
+		}
+		return r
+	}, answer)
+
+	return strings.Join(strings.Fields(answer), " ")
+}
+
+func editDistance(a, b string) int {
+
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Grade typed answer, result is used as quiz callback for readQuality
+func gradeTypedAnswer(typed, expected string) string {
+
+	typed = normalizeAnswer(typed)
+	expected = normalizeAnswer(expected)
+
+	if typed == expected {
+		return "correctAnswer"
+	}
+
+	// One typo per five letters, short words must be exact
+	tolerance := len([]rune(expected)) / 5
+	if tolerance == 0 && len([]rune(expected)) > 3 {
+		tolerance = 1
+	}
+
+	if typed != "" && editDistance(typed, expected) <= tolerance {
+		return "nearAnswer"
+	}
+
+	return "incorrectAnswer"
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestGradeTypedAnswer(t *testing.T) {
+
+	tests := []struct {
+		typed    string
+		expected string
+		result   string
+	}{
+		{"cat", "cat", "correctAnswer"},
+		{"  Cat! ", "cat", "correctAnswer"},
+		{"cafe", "café", "correctAnswer"},
+		{"ice-cream", "ice cream", "correctAnswer"},
+		{"ёж", "еж", "correctAnswer"},
+		// Cyrillic с, о, р and е typed instead of latin letters
+		{"сорe", "cope", "correctAnswer"},
+		{"хаос", "xaoc", "correctAnswer"},
+		{"housr", "house", "nearAnswer"},
+		{"elephnt", "elephant", "nearAnswer"},
+		{"cot", "cat", "incorrectAnswer"},
+		{"dog", "cat", "incorrectAnswer"},
+		{"", "house", "incorrectAnswer"},
+		{"hose", "house", "nearAnswer"},
+		{"horse", "mouse", "incorrectAnswer"},
+		// Letters which look alike only in upper case
+		{"нот", "hot", "incorrectAnswer"},
+		{"вет", "bet", "incorrectAnswer"},
+		{"мак", "mak", "incorrectAnswer"},
+	}
+
+	for _, test := range tests {
+		if result := gradeTypedAnswer(test.typed, test.expected); result != test.result {
+			t.Errorf("gradeTypedAnswer(%q, %q) = %s, want %s", test.typed, test.expected, result, test.result)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Used when user hasn't set own limits, see User.NewCardsPerDay and User.ReviewsPerDay
+var (
+	defaultNewCardsPerDay = 20
+	defaultReviewsPerDay  = 200
+)
+
+// Answers given today, counters start from zero every day
+type DailyCount struct {
+	Date     string `bson:"date"`
+	NewCards int    `bson:"newCards"`
+	Reviews  int    `bson:"reviews"`
+	// Answers by dictionary id, for limits of user dictionaries
+	Dictionaries map[string]int `bson:"dictionaries,omitempty"`
+	// Cards counted today, see cardKey, card asked again after wrong answer isn't counted twice
+	Cards []string `bson:"cards,omitempty"`
+}
+
+// Day is counted in user timezone, so limits are renewed at user midnight
+func userToday(userId int) string {
+	return userNow(userId).Format("2006-01-02")
+}
+
+func loadDailyLimitsFromBase(userId int) (newCardsPerDay int, reviewsPerDay int, err error) {
+
+	newCardsPerDay, reviewsPerDay = defaultNewCardsPerDay, defaultReviewsPerDay
+
+	user, err := loadUserFromBase(userId)
+	if err == mongo.ErrNoDocuments {
+		return newCardsPerDay, reviewsPerDay, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	if user.NewCardsPerDay > 0 {
+		newCardsPerDay = user.NewCardsPerDay
+	}
+	if user.ReviewsPerDay > 0 {
+		reviewsPerDay = user.ReviewsPerDay
+	}
+
+	return newCardsPerDay, reviewsPerDay, nil
+}
+
+func dumpNewCardsPerDayToBase(userId int, newCardsPerDay int) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$set": bson.M{"new_cards_per_day": newCardsPerDay}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func dumpReviewsPerDayToBase(userId int, reviewsPerDay int) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$set": bson.M{"reviews_per_day": reviewsPerDay}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func loadDailyCountFromBase(userId int) (DailyCount, error) {
+
+	today := userToday(userId)
+
+	user, err := loadUserFromBase(userId)
+	if err == mongo.ErrNoDocuments {
+		return DailyCount{Date: today}, nil
+	} else if err != nil {
+		return DailyCount{}, err
+	}
+
+	// Counters of previous day aren't reset in base, they are just ignored
+	if user.DailyCount.Date != today {
+		return DailyCount{Date: today}, nil
+	}
+
+	return user.DailyCount, nil
+}
+
+// Count answered card once a day, card is new if it was never reviewed before the answer
+func countAnswerInBase(userId int, fact *Fact) error {
+
+	today := userToday(userId)
+	card := cardKey(*fact)
+	dictionaryField := "daily.dictionaries." + fact.DictionaryID.Hex()
+	field := "daily.reviews"
+	count := DailyCount{Date: today, Reviews: 1, Dictionaries: map[string]int{fact.DictionaryID.Hex(): 1}, Cards: []string{card}}
+	if fact.IntervalFrom == "" {
+		field = "daily.newCards"
+		count.Reviews, count.NewCards = 0, 1
+	}
+
+	// Card is checked in the same update, so answers at the same time are counted once too
+	result, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId, "daily.date": today, "daily.cards": bson.M{"$ne": card}},
+		bson.M{"$inc": bson.M{field: 1, dictionaryField: 1}, "$push": bson.M{"daily.cards": card}},
+	)
+	if err != nil {
+		return err
+	}
+
+	// First answer of the day, nothing is matched if card is counted already
+	if result.MatchedCount == 0 {
+		_, err = usersCollection.UpdateOne(
+			context.TODO(),
+			bson.M{"user.id": userId, "daily.date": bson.M{"$ne": today}},
+			bson.M{"$set": bson.M{"daily": count}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Keep no more new and reviewed cards than left for today, order of cards is kept
+func applyDailyLimits(userId int, forReview FactSet) (FactSet, error) {
+
+	newCardsPerDay, reviewsPerDay, err := loadDailyLimitsFromBase(userId)
+	if err != nil {
+		return nil, err
+	}
+	count, err := loadDailyCountFromBase(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	newCardsLeft := newCardsPerDay - count.NewCards
+	reviewsLeft := reviewsPerDay - count.Reviews
+
+	var limited FactSet
+	for _, fact := range forReview {
+		if fact.IntervalFrom == "" {
+			if newCardsLeft > 0 {
+				limited = append(limited, fact)
+				newCardsLeft--
+			}
+		} else if reviewsLeft > 0 {
+			limited = append(limited, fact)
+			reviewsLeft--
+		}
+	}
+
+	return limited, nil
+}
+
+func dailySummary(userId int) string {
+
+	newCardsPerDay, reviewsPerDay, err := loadDailyLimitsFromBase(userId)
+	if err != nil {
+		return ""
+	}
+	count, err := loadDailyCountFromBase(userId)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("Today new cards: %d of %d\nToday reviews: %d of %d", count.NewCards, newCardsPerDay, count.Reviews, reviewsPerDay)
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/burke/nanomemo/supermemo"
-	"github.com/go-co-op/gocron"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
@@ -22,10 +24,13 @@ You can control me by sending commands:
 /help - Show this help
 /quiz - Start learning
 /hot20 - Repeat 20 random words from your dictionary
+/stats - Show your learning statistics
 /settings - Configure bot parameters
 /pushdict - Push your own dictionary
 /pulldict - Pull your own dictionary
-/settime - Set reminder time
+/settime - Set reminders
+/settimezone - Set your timezone
+/cancel - Cancel current action
 `
 
 var mainMenuKeyboard = tgbotapi.NewInlineKeyboardMarkup(
@@ -35,6 +40,9 @@ var mainMenuKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 	tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("Hot20", "hot20"),
 	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Stats", "stats"),
+	),
 	tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("Settings", "settings"),
 	),
@@ -44,6 +52,9 @@ var settingsKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 	tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("Pick dictionary", "pickDict"),
 	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("My dictionaries", "myDicts"),
+	),
 	tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("Push dictionary", "pushDict"),
 	),
@@ -51,38 +62,99 @@ var settingsKeyboard = tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardButtonData("Pull dictionary", "pullDict"),
 	),
 	tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData("Set reminder time", "setRemTime"),
+		tgbotapi.NewInlineKeyboardButtonData("Reminders", "setRemTime"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Set timezone", "setTimezone"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Quiz mode", "quizMode"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Daily limits", "dailyLimits"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Scheduling algorithm", "scheduler"),
 	),
 	tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("<< Back", "backToMain"),
 	),
 )
 
-var (
-	libraryForReview = map[int]supermemo.FactSet{}
-	indexForReview   = map[int]int{}
-	membership       = map[int]string{}
-	remindsChart     = map[int]string{}
+var quizModeKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Choose answer", "setQuizModeChoice"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Type answer", "setQuizModeTyping"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("2 answers", "setAnswerOptions2"),
+		tgbotapi.NewInlineKeyboardButtonData("4 answers", "setAnswerOptions4"),
+		tgbotapi.NewInlineKeyboardButtonData("6 answers", "setAnswerOptions6"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", "backToSettings"),
+	),
 )
 
-type Stopwatch struct {
-	start time.Time
-	mark  time.Duration
-}
+var dailyLimitsKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("10 new", "setNewPerDay10"),
+		tgbotapi.NewInlineKeyboardButtonData("20 new", "setNewPerDay20"),
+		tgbotapi.NewInlineKeyboardButtonData("50 new", "setNewPerDay50"),
+		tgbotapi.NewInlineKeyboardButtonData("100 new", "setNewPerDay100"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("100 reviews", "setReviewsPerDay100"),
+		tgbotapi.NewInlineKeyboardButtonData("200 reviews", "setReviewsPerDay200"),
+		tgbotapi.NewInlineKeyboardButtonData("500 reviews", "setReviewsPerDay500"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", "backToSettings"),
+	),
+)
 
-var (
-	stopwatch = map[int]Stopwatch{}
-	quality   = map[int]int{}
+var schedulerKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("SM-2", "setScheduler"+sm2SchedulerName),
+		tgbotapi.NewInlineKeyboardButtonData("FSRS", "setScheduler"+fsrsSchedulerName),
+		tgbotapi.NewInlineKeyboardButtonData("Leitner boxes", "setScheduler"+leitnerSchedulerName),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", "backToSettings"),
+	),
 )
 
+var statsKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Forecast", "chartForecast"),
+		tgbotapi.NewInlineKeyboardButtonData("Heatmap", "chartHeatmap"),
+		tgbotapi.NewInlineKeyboardButtonData("Easiness", "chartEase"),
+	),
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", "backToMain"),
+	),
+)
+
+const (
+	quizModeChoice = "choice"
+	quizModeTyping = "typing"
+)
+
+var hot20Size = 20
+
 var defaultLibraryDirPath = "./configs/dictionaries"
 var defaultDictionaryName = "owsi.csv"
 var defaultDictionaryId primitive.ObjectID
 
-// Set waiting bool
-//var waitingForDictionaryID = false
-var waitingForDictionaryFile = false
-var waitingForTime = false
+// Several replicas of bot get updates by webhook at WEBHOOK_URL and share sessions in base.
+// Without it bot polls for updates and keeps sessions in memory, only one replica can poll
+var webhookURL = strings.TrimSuffix(os.Getenv("WEBHOOK_URL"), "/")
+var webhookPort = "8080"
+
+// Admins of native group change quota of user by /setquota, see setDictionaryQuota
+var defaultDictionaryQuota = 5
 
 func main() {
 	// Create bot
@@ -96,43 +168,89 @@ func main() {
 
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	// Set up timeout
-	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = 60
+	var updates tgbotapi.UpdatesChannel
+	if webhookURL != "" {
 
-	// Get updates from bot
-	updates, err := bot.GetUpdatesChan(updateConfig)
-	if err != nil {
-		log.Panic(err)
-	}
+		// Token in path keeps others from sending updates
+		if _, err = bot.SetWebhook(tgbotapi.NewWebhook(webhookURL + "/" + bot.Token)); err != nil {
+			log.Panic(err)
+		}
+		updates = bot.ListenForWebhook("/" + bot.Token)
 
-	// Optional: wait for updates and clear them if you don't want to handle
-	// a large backlog of old messages
-	time.Sleep(time.Millisecond * 500)
-	updates.Clear()
+	} else {
+
+		// Telegram doesn't give updates to poller while webhook is set
+		if _, err = bot.RemoveWebhook(); err != nil {
+			log.Panic(err)
+		}
+
+		// Set up timeout
+		updateConfig := tgbotapi.NewUpdate(0)
+		updateConfig.Timeout = 60
+
+		// Get updates from bot
+		updates, err = bot.GetUpdatesChan(updateConfig)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		// Optional: wait for updates and clear them if you don't want to handle
+		// a large backlog of old messages
+		time.Sleep(time.Millisecond * 500)
+		updates.Clear()
+	}
 
 	// Connect to database
 	if err := connectMongoDb(); err != nil {
 		log.Panic(err)
 	}
 
+	// Replicas see the same sessions
+	if webhookURL != "" {
+		sessions = newMongoSessionStore()
+	}
+
+	// Give IDs to facts of old dictionaries
+	if err := migrateFactIDsInBase(); err != nil {
+		log.Panic(err)
+	}
+
 	// Create and fill default library in database
 	if err := updateDefaultLibrary(defaultLibraryDirPath); err != nil {
 		log.Panic(err)
 	}
 
-	// Fill users map for security checking
-	if membership, err = loadAllUsersStatusFromBase(); err != nil {
+	// Replace old per user copies of dictionaries with progress
+	if err := migrateUserCopiesInBase(); err != nil {
 		log.Panic(err)
 	}
 
+	// Fill users sessions for security checking
+	if err = reloadMembership(); err != nil {
+		log.Panic(err)
+	}
+
+	// Continue quizzes interrupted by restart, sessions in base survive it by themselves
+	if webhookURL == "" {
+		if err = restoreQuizSessions(); err != nil {
+			log.Panic(err)
+		}
+	}
+
 	// Add anyflashcardsbot user to database
 	if err = addNewUser(bot, &bot.Self); err != nil {
 		log.Panic(err)
 	}
 
-	// Fill reminderChart map for remingding
-	setAllReminds(bot)
+	// Schedule reminders of all users
+	startReminders(bot)
+
+	// Webhook is served when start is done, so pod is ready only when it can handle updates
+	if webhookURL != "" {
+		go func() {
+			log.Panic(http.ListenAndServe(":"+webhookPort, nil))
+		}()
+	}
 
 	// Go through each update that we're getting from Telegram.
 	for update := range updates {
@@ -147,22 +265,15 @@ func main() {
 			if update.Message.NewChatMembers != nil {
 				log.Printf("\"update.Message.NewChatMembers != nil\": %v\n", "update.Message.NewChatMembers != nil")
 				addNewUsers(bot, update.Message.NewChatMembers)
-				destinationId, err := copyDictionaryInBase(&defaultDictionaryId)
-				var meta = DictionaryMetadata{
-					Date:    time.Now(),
-					OwnerID: update.Message.From.ID,
-					Status:  "current"}
-
-				if err != nil {
-					log.Printf("err: %v\n", err)
-				}
-				if err := setDictionaryMetaInBase(destinationId, meta); err != nil {
-					log.Printf("err: %v\n", err)
+				for _, newMember := range *update.Message.NewChatMembers {
+					if err := pickUserDictionaryInBase(newMember.ID, defaultDictionaryId); err != nil {
+						log.Printf("err: %v\n", err)
+					}
 				}
 			}
 			if update.Message.LeftChatMember != nil {
 				blockUser(bot, update.Message.LeftChatMember.ID)
-				if membership, err = loadAllUsersStatusFromBase(); err != nil {
+				if err = reloadMembership(); err != nil {
 					log.Printf("err: %v\n", err)
 				}
 			}
@@ -175,39 +286,90 @@ func main() {
 			} else if command == "help" {
 				showMainMeny(bot, update.Message.From.ID)
 
+			} else if command == "hot20" {
+				startHot20(bot, update.Message.From)
+
+			} else if command == "setquota" {
+				setDictionaryQuota(bot, update.Message)
+
+			} else if command == "stats" {
+				if err := showStats(bot, update.Message.From.ID); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+
 			} else if command == "settings" {
 				showSettings(bot, update)
 
+			} else if command == "pushdict" {
+				askForDictionary(bot, update.Message.From.ID)
+
+			} else if command == "pulldict" {
+				if err := pullActiveDictionary(bot, update.Message.From.ID); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+
+			} else if command == "settime" {
+				if err := showReminders(bot, update.Message.From.ID); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+
+			} else if command == "settimezone" {
+				askForTimezone(bot, update.Message.From.ID)
+
+			} else if command == "cancel" {
+				setState(update.Message.From.ID, Idle)
+				forgetQuizSession(update.Message.From.ID)
+				showMessage(bot, update.Message.From.ID, "Canceled.")
+				showMainMeny(bot, update.Message.From.ID)
+
 				// Add commands hear
 
 			} else if update.Message.IsCommand() {
 				showMessage(bot, update.Message.From.ID, "Unrecognized command. Use /help.")
 			}
 
+			// Handle waiting for user input
+			state := getState(update.Message.From.ID)
+
 			// Handle file
-			if waitingForDictionaryFile {
+			if state == AwaitingDictionary && !update.Message.IsCommand() {
 				if _id, err := pushDictionaryToBase(bot, &update); err != nil {
 					log.Printf("err: %v\n", err)
-				} else {
-					if err = organizePrivateUserDictionariesInBase(update.Message.From.ID); err != nil {
-						log.Printf("err: %v\n", err)
-					}
-					var meta = DictionaryMetadata{Date: time.Now(), OwnerID: update.Message.From.ID, Status: "current"}
-					if err = setDictionaryMetaInBase(_id, meta); err != nil {
+				} else if _id != nil {
+					if err = pickUserDictionaryInBase(update.Message.From.ID, *_id); err != nil {
 						log.Printf("err: %v\n", err)
 					}
 				}
 
-			} else if !waitingForDictionaryFile && update.Message.Document != nil {
+			} else if state != AwaitingDictionary && update.Message.Document != nil {
+
+				showMessage(bot, update.Message.From.ID, "For pushing your dictionary use /pushdict")
+			}
+
+			// Handle typed answer
+			if state == InQuiz && sessions.Get(update.Message.From.ID).Typing && !update.Message.IsCommand() && update.Message.Text != "" {
+				answerTyped(bot, update.Message)
+			}
 
-				showMessage(bot, update.Message.From.ID, "For pushing your dictionary use /pushDict")
+			// Handle new name of dictionary
+			if state == AwaitingDictionaryName && !update.Message.IsCommand() {
+				renameDictionary(bot, update.Message)
+			}
+
+			// Handle timezone name or location
+			if state == AwaitingTimezone && !update.Message.IsCommand() {
+				timezone := update.Message.Text
+				if update.Message.Location != nil {
+					timezone = timezoneByLocation(update.Message.Location.Latitude, update.Message.Location.Longitude)
+				}
+				if err := setTimezone(bot, update.Message.From.ID, timezone); err != nil {
+					log.Printf("err: %v\n", err)
+				}
 			}
 
 			// Handle time for seting reminder
-			if waitingForTime {
-				dumpReminderToBase(update.Message.From.ID, update.Message.Text)
-				setAllReminds(bot)
-				waitingForTime = false
+			if state == AwaitingReminderTime && !update.Message.IsCommand() {
+				addTypedReminder(bot, update.Message.From.ID, update.Message.Text)
 			}
 
 		} else if update.CallbackQuery != nil {
@@ -217,37 +379,46 @@ func main() {
 
 			if callback == "quiz" {
 
-				indexForReview[update.CallbackQuery.From.ID] = 0
-				stopwatch[update.CallbackQuery.From.ID] = Stopwatch{}
-
-				// Update libraryForReview
-				factSet, err := loadFactsFromBase(update.CallbackQuery.From)
+				// Update facts for review from all current dictionaries
+				cards, forReview, err := loadQuizFromBase(update.CallbackQuery.From.ID)
 				if err != nil {
 					log.Panic(err)
 				}
-				smFactSet := convertToSupermemoFactSet(&factSet)
-				libraryForReview[update.CallbackQuery.From.ID] = smFactSet.ForReview()
+				typing, answerOptions := loadQuizSettings(update.CallbackQuery.From.ID)
+				sessions.Update(update.CallbackQuery.From.ID, func(session *Session) {
+					session.ForReview = forReview
+					session.Cards = cards
+					session.QuizID = newQuizID()
+					session.Index = 0
+					session.Practice = false
+					session.Typing = typing
+					session.AnswerOptions = answerOptions
+					session.Stopwatch = Stopwatch{}
+					session.Right = 0
+					session.Wrong = 0
+				})
 
 				nextQuestion(bot, update.CallbackQuery.From.ID, update.CallbackQuery.Data)
 			}
 
-			if callback == "correctAnswer" {
-				forReview := libraryForReview[update.CallbackQuery.From.ID]
-				index := indexForReview[update.CallbackQuery.From.ID] - 1
-				msg := "Right!: " + forReview[index].Question + " - " + forReview[index].Answer
-				calbackAnswer := tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, msg)
-				bot.AnswerCallbackQuery(calbackAnswer)
-				nextQuestion(bot, update.CallbackQuery.From.ID, update.CallbackQuery.Data)
+			if callback == "hot20" {
+				startHot20(bot, update.CallbackQuery.From)
+			}
 
+			if callback == "stats" {
+				if err := showStats(bot, update.CallbackQuery.From.ID); err != nil {
+					log.Printf("err: %v\n", err)
+				}
 			}
 
-			if callback == "incorrectAnswer" {
-				forReview := libraryForReview[update.CallbackQuery.From.ID]
-				index := indexForReview[update.CallbackQuery.From.ID] - 1
-				msg := "Wrong!: " + forReview[index].Question + " - " + forReview[index].Answer
-				calbackAnswer := tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, msg)
-				bot.AnswerCallbackQuery(calbackAnswer)
-				nextQuestion(bot, update.CallbackQuery.From.ID, update.CallbackQuery.Data)
+			if strings.HasPrefix(callback, "chart") {
+				if err := showChart(bot, update.CallbackQuery.From.ID, callback); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+			}
+
+			if quizCallback, ok := parseQuizCallback(callback); ok {
+				handleQuizCallback(bot, update.CallbackQuery, quizCallback)
 			}
 
 			// Newbie check (maybe add later)
@@ -258,33 +429,141 @@ func main() {
 
 			if callback == "pickDict" {
 				showPickDictKeyboard(bot, update.CallbackQuery.From.ID)
-				//waitingForDictionaryID = true
 			}
 
 			if primitive.IsValidObjectID(callback) {
 				dictionaryId, _ := primitive.ObjectIDFromHex(callback)
-				resultDictionaryId, _ := copyDictionaryInBase(&dictionaryId)
-				organizePrivateUserDictionariesInBase(update.CallbackQuery.From.ID)
-
-				var meta = DictionaryMetadata{
-					Date:     time.Now(),
-					FilePath: callback,
-					OwnerID:  update.CallbackQuery.From.ID,
-					Status:   "current"}
+				if reached, err := dictionaryQuotaReached(update.CallbackQuery.From.ID, dictionaryId); err != nil {
+					log.Printf("err: %v\n", err)
+				} else if reached {
+					bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, quotaReachedMessage))
+				} else {
+					if err := pickDictionary(update.CallbackQuery.From.ID, dictionaryId); err != nil {
+						log.Printf("err: %v\n", err)
+					}
+					showPickDictKeyboard(bot, update.CallbackQuery.From.ID)
+				}
+			}
 
-				setDictionaryMetaInBase(resultDictionaryId, meta)
-				showPickDictKeyboard(bot, update.CallbackQuery.From.ID)
+			if callback == "myDicts" {
+				if text, keyboard, err := myDictionariesKeyboard(update.CallbackQuery.From.ID); err != nil {
+					log.Printf("err: %v\n", err)
+				} else {
+					editDictionaryScreen(bot, update.CallbackQuery, text, keyboard)
+				}
+			}
 
+			if action, dictionaryId, ok := parseDictionaryCallback(callback); ok {
+				if err := handleDictionaryCallback(bot, update.CallbackQuery, action, dictionaryId); err != nil {
+					log.Printf("err: %v\n", err)
+				}
 			}
 
 			if callback == "pushDict" {
-				showMessage(bot, update.CallbackQuery.From.ID, "Waiting for your own dictionary .csv file.")
-				waitingForDictionaryFile = true
+				askForDictionary(bot, update.CallbackQuery.From.ID)
+			}
+
+			if callback == "pullDict" {
+				if err := pullActiveDictionary(bot, update.CallbackQuery.From.ID); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+			}
+
+			if callback == "quizMode" {
+				msg := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, "How do you want to answer?")
+				kbrd := tgbotapi.NewEditMessageReplyMarkup(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, quizModeKeyboard)
+				bot.Send(msg)
+				bot.Send(kbrd)
+			}
+
+			if callback == "setQuizModeChoice" || callback == "setQuizModeTyping" {
+				quizMode := quizModeChoice
+				if callback == "setQuizModeTyping" {
+					quizMode = quizModeTyping
+				}
+				if err := dumpQuizModeToBase(update.CallbackQuery.From.ID, quizMode); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+				bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, "Quiz mode is set."))
+			}
+
+			if strings.HasPrefix(callback, "setAnswerOptions") {
+				answerOptions, _ := strconv.Atoi(strings.TrimPrefix(callback, "setAnswerOptions"))
+				if err := dumpAnswerOptionsToBase(update.CallbackQuery.From.ID, answerOptions); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+				bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, "Number of answers is set."))
+			}
+
+			if callback == "dailyLimits" {
+				newCardsPerDay, reviewsPerDay, err := loadDailyLimitsFromBase(update.CallbackQuery.From.ID)
+				if err != nil {
+					log.Printf("err: %v\n", err)
+				}
+				text := fmt.Sprintf("How many cards a day?\nNew cards: %d\nReviews: %d", newCardsPerDay, reviewsPerDay)
+				msg := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, text)
+				kbrd := tgbotapi.NewEditMessageReplyMarkup(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, dailyLimitsKeyboard)
+				bot.Send(msg)
+				bot.Send(kbrd)
+			}
+
+			if strings.HasPrefix(callback, "setNewPerDay") {
+				newCardsPerDay, _ := strconv.Atoi(strings.TrimPrefix(callback, "setNewPerDay"))
+				if err := dumpNewCardsPerDayToBase(update.CallbackQuery.From.ID, newCardsPerDay); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+				bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, "New cards per day are set."))
+			}
+
+			if strings.HasPrefix(callback, "setReviewsPerDay") {
+				reviewsPerDay, _ := strconv.Atoi(strings.TrimPrefix(callback, "setReviewsPerDay"))
+				if err := dumpReviewsPerDayToBase(update.CallbackQuery.From.ID, reviewsPerDay); err != nil {
+					log.Printf("err: %v\n", err)
+				}
+				bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, "Reviews per day are set."))
+			}
+
+			if callback == "scheduler" {
+				text := "When do you want to see cards again? Dictionaries can have own algorithm in My dictionaries."
+				msg := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, text)
+				kbrd := tgbotapi.NewEditMessageReplyMarkup(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, schedulerKeyboard)
+				bot.Send(msg)
+				bot.Send(kbrd)
+			}
+
+			if strings.HasPrefix(callback, "setScheduler") {
+				scheduler := strings.TrimPrefix(callback, "setScheduler")
+				if err := dumpSchedulerToBase(update.CallbackQuery.From.ID, scheduler); err != nil {
+					log.Printf("err: %v\n", err)
+					bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, "Can't set algorithm."))
+				} else {
+					bot.AnswerCallbackQuery(tgbotapi.NewCallback(update.CallbackQuery.ID, schedulerTitles[scheduler]+" is set."))
+				}
+			}
+
+			if callback == "setTimezone" {
+				askForTimezone(bot, update.CallbackQuery.From.ID)
+			}
+
+			if strings.HasPrefix(callback, "setTimezone") && callback != "setTimezone" {
+				if err := setTimezone(bot, update.CallbackQuery.From.ID, strings.TrimPrefix(callback, "setTimezone")); err != nil {
+					log.Printf("err: %v\n", err)
+				}
 			}
 
 			if callback == "setRemTime" {
-				showMessage(bot, update.CallbackQuery.From.ID, "Waiting for your time string. Send string like 20:00.")
-				waitingForTime = true
+				if text, keyboard, err := remindersKeyboard(update.CallbackQuery.From.ID); err != nil {
+					log.Printf("err: %v\n", err)
+				} else {
+					setState(update.CallbackQuery.From.ID, AwaitingReminderTime)
+					editDictionaryScreen(bot, update.CallbackQuery, text, keyboard)
+				}
+			}
+
+			if isReminderCallback(callback) {
+				if err := handleReminderCallback(bot, update.CallbackQuery); err != nil {
+					log.Printf("err: %v\n", err)
+				}
 			}
 
 			if callback == "backToMain" {
@@ -352,6 +631,18 @@ func showSettings(bot *tgbotapi.BotAPI, update tgbotapi.Update) error {
 	return nil
 }
 
+func askForDictionary(bot *tgbotapi.BotAPI, userId int) {
+	if reached, err := dictionaryQuotaReached(userId, primitive.NilObjectID); err != nil {
+		log.Printf("err: %v\n", err)
+	} else if reached {
+		showMessage(bot, userId, quotaReachedMessage)
+		return
+	}
+
+	setState(userId, AwaitingDictionary)
+	showMessage(bot, userId, "Waiting for your own dictionary .csv file. Use /cancel to stop waiting.")
+}
+
 func showPickDictKeyboard(bot *tgbotapi.BotAPI, userId int) error {
 
 	publicDictionaries, err := loadAllPublicDictionaryFromBase()
@@ -364,13 +655,26 @@ func showPickDictKeyboard(bot *tgbotapi.BotAPI, userId int) error {
 	}
 	availableDictionaries := append(publicDictionaries, privateUserDictionaries...)
 
+	active, err := loadActiveDictionariesFromBase(userId)
+	if err != nil {
+		log.Printf("err: %v\n", err)
+	}
+	current := map[primitive.ObjectID]bool{}
+	for _, dictionary := range active {
+		current[dictionary.ID] = true
+	}
+
 	msg := tgbotapi.NewMessage(int64(userId), "Pick your dictionary:")
 	pickDictKeyboard := tgbotapi.NewInlineKeyboardMarkup()
 
 	for _, dictionary := range availableDictionaries {
 		var row []tgbotapi.InlineKeyboardButton
+		status := dictionary.DictionaryMetadata.Status
+		if current[dictionary.ID] {
+			status = "current"
+		}
 		btnText := "Name: " + dictionary.DictionaryMetadata.Name +
-			"\nStatus:" + dictionary.DictionaryMetadata.Status +
+			"\nStatus:" + status +
 			"\nDate:" + dictionary.DictionaryMetadata.Date.Local().Format("2006-January-02 15:04:05")
 		btn := tgbotapi.NewInlineKeyboardButtonData(btnText, dictionary.ID.Hex())
 		row = append(row, btn)
@@ -389,48 +693,147 @@ func showPickDictKeyboard(bot *tgbotapi.BotAPI, userId int) error {
 	return nil
 }
 
+// Pick dictionary without copying, only public and own dictionaries are available
+func pickDictionary(userId int, dictionaryId primitive.ObjectID) error {
+
+	if err := checkDictionaryAccess(userId, dictionaryId); err != nil {
+		return err
+	}
+
+	return pickUserDictionaryInBase(userId, dictionaryId)
+}
+
+func checkDictionaryAccess(userId int, dictionaryId primitive.ObjectID) error {
+
+	dictionary, err := loadDictionaryFromBase(&dictionaryId)
+	if err != nil {
+		return err
+	}
+
+	if dictStatuses[dictionary.DictionaryMetadata.Status] != "public" && dictionary.DictionaryMetadata.OwnerID != userId {
+		return fmt.Errorf("dictionary %v isn't available for user %v", dictionaryId.Hex(), userId)
+	}
+
+	return nil
+}
+
 func showAnswerKeybord(bot *tgbotapi.BotAPI, userId int) error {
 
-	forReview := libraryForReview[userId]
-	index := indexForReview[userId]
+	session := sessions.Get(userId)
+	forReview := session.ForReview
+	index := session.Index
 
 	log.Printf("len(forReview): %v\n", len(forReview))
 	log.Printf("index: %v\n", index)
 
-	// Make slice for randomization
-	forRandomization := make(supermemo.FactSet, len(forReview))
-	copy(forRandomization, forReview)
-
-	forRandomization = append(forRandomization[:index], forRandomization[index+1:]...)
-	arrayOfFourPosibleAnswer := make([][]string, 4)
-
-	// Fill slice for randomization
-	for i := 0; i < 4; i++ {
+	// Wrong answers are taken from whole dictionary, restored quiz has only facts for review
+	candidates := session.Cards
+	if len(candidates) == 0 {
+		candidates = forReview
+	}
+	answerOptions := session.AnswerOptions
+	if answerOptions == 0 {
+		answerOptions = defaultAnswerOptions
+	}
+	distractors := pickDistractors(forReview[index], candidates, answerOptions-1)
 
-		log.Printf("i: %v\n", i)
-		arrayOfFourPosibleAnswer[i] = []string{"   ...   ", "incorrectAnswer"}
+	// Small dictionary gives less options, correct answer still lands on random place
+	options, correctPosition := answerShuffler.Shuffle(forReview[index].Answer, distractors)
 
-		if len(forRandomization) > 0 {
-			randomPosition := rand.Intn(len(forRandomization))
-			arrayOfFourPosibleAnswer[i] = []string{forRandomization[randomPosition].Answer, "incorrectAnswer"}
-			forRandomization = append(forRandomization[:randomPosition], forRandomization[randomPosition+1:]...)
+	quizKeyboard := tgbotapi.NewInlineKeyboardMarkup()
+	position := 0
+	for _, buttons := range answerLayout(len(options)) {
+		var row []tgbotapi.InlineKeyboardButton
+		for i := 0; i < buttons; i++ {
+			data := QuizCallback{Action: answerAction, QuizID: session.QuizID, Card: cardKey(forReview[index]), Option: position}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(options[position], data.String()))
+			position++
 		}
+		quizKeyboard.InlineKeyboard = append(quizKeyboard.InlineKeyboard, row)
 	}
+	quizKeyboard.InlineKeyboard = append(quizKeyboard.InlineKeyboard, quizControlRow(session.QuizID, forReview[index]))
 
-	randomOfFour := rand.Intn(3)
-	arrayOfFourPosibleAnswer[randomOfFour] = []string{forReview[index].Answer, "correctAnswer"}
+	// Correct answer is checked on server side, callback carries only position
+	sessions.Update(userId, func(session *Session) {
+		session.CorrectOption = correctPosition
+		session.Options = options
+	})
+	msg := tgbotapi.NewMessage(int64(userId), forReview[index].Question)
+	msg.ReplyMarkup = quizKeyboard
+	if _, err := bot.Send(msg); err != nil {
+		return err
+	}
+
+	return nil
+}
 
-	quizKeyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(arrayOfFourPosibleAnswer[0][0], arrayOfFourPosibleAnswer[0][1]),
-			tgbotapi.NewInlineKeyboardButtonData(arrayOfFourPosibleAnswer[1][0], arrayOfFourPosibleAnswer[1][1]),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(arrayOfFourPosibleAnswer[2][0], arrayOfFourPosibleAnswer[2][1]),
-			tgbotapi.NewInlineKeyboardButtonData(arrayOfFourPosibleAnswer[3][0], arrayOfFourPosibleAnswer[3][1]),
-		),
+// Row with blackout and stop buttons for the question about the card
+func quizControlRow(quizId string, card Fact) []tgbotapi.InlineKeyboardButton {
+
+	blackout := QuizCallback{Action: blackoutAction, QuizID: quizId, Card: cardKey(card)}
+	stop := QuizCallback{Action: stopAction, QuizID: quizId, Card: cardKey(card)}
+
+	return tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("I don't know", blackout.String()),
+		tgbotapi.NewInlineKeyboardButtonData("Stop", stop.String()),
 	)
-	msg := tgbotapi.NewMessage(int64(userId), forReview[index].Question)
+}
+
+// Check that pressed button belongs to question waiting for answer, then answer it
+func handleQuizCallback(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery, quizCallback QuizCallback) {
+
+	userId := callbackQuery.From.ID
+	session := sessions.Get(userId)
+
+	if getState(userId) != InQuiz || quizCallback.QuizID != session.QuizID {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callbackQuery.ID, "This quiz is over. Press Quiz to start a new one."))
+		return
+	}
+
+	if quizCallback.Action == stopAction {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackQuery.ID, "Stopped"))
+		stopQuiz(bot, userId)
+		return
+	}
+
+	// Question under index-1 is shown now, older ones are already answered
+	if session.Index < 1 || session.Index > len(session.ForReview) || quizCallback.Card != cardKey(session.ForReview[session.Index-1]) {
+		bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callbackQuery.ID, "This question is already answered."))
+		return
+	}
+
+	answered := session.ForReview[session.Index-1]
+	result := "blackout"
+	msg := "Remember: " + answered.Question + " - " + answered.Answer
+
+	// Options of restored quiz are lost, so chosen answer may be unknown
+	chosen := ""
+	if quizCallback.Action == answerAction && quizCallback.Option < len(session.Options) {
+		chosen = session.Options[quizCallback.Option]
+	}
+	sessions.Update(userId, func(session *Session) {
+		session.Answer = chosen
+	})
+
+	if quizCallback.Action == answerAction && quizCallback.Option == session.CorrectOption {
+		result = "correctAnswer"
+		msg = "Right!: " + answered.Question + " - " + answered.Answer
+
+	} else if quizCallback.Action == answerAction {
+		result = "incorrectAnswer"
+		msg = "Wrong!: " + answered.Question + " - " + answered.Answer
+	}
+
+	bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callbackQuery.ID, msg))
+	nextQuestion(bot, userId, result)
+}
+
+func showTypingQuestion(bot *tgbotapi.BotAPI, userId int) error {
+
+	session := sessions.Get(userId)
+
+	quizKeyboard := tgbotapi.NewInlineKeyboardMarkup(quizControlRow(session.QuizID, session.ForReview[session.Index]))
+	msg := tgbotapi.NewMessage(int64(userId), session.ForReview[session.Index].Question)
 	msg.ReplyMarkup = quizKeyboard
 	if _, err := bot.Send(msg); err != nil {
 		return err
@@ -439,131 +842,389 @@ func showAnswerKeybord(bot *tgbotapi.BotAPI, userId int) error {
 	return nil
 }
 
-func nextQuestion(bot *tgbotapi.BotAPI, userId int, callbackQueryData string) {
+func answerTyped(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 
-	forReview := libraryForReview[userId]
-	index := indexForReview[userId]
+	session := sessions.Get(message.From.ID)
+	answered := session.ForReview[session.Index-1]
 
-	// Make slice for randomization
-	forRandomization := make(supermemo.FactSet, len(forReview))
-	copy(forRandomization, forReview)
+	result := gradeTypedAnswer(message.Text, answered.Answer)
+	sessions.Update(message.From.ID, func(session *Session) {
+		session.Answer = message.Text
+	})
+	verdicts := map[string]string{
+		"correctAnswer":   "Right!: ",
+		"nearAnswer":      "Almost right!: ",
+		"incorrectAnswer": "Wrong!: ",
+	}
+	showMessage(bot, message.From.ID, verdicts[result]+answered.Question+" - "+answered.Answer)
+
+	nextQuestion(bot, message.From.ID, result)
+}
+
+func loadQuizSettings(userId int) (typing bool, answerOptions int) {
+
+	user, err := loadUserFromBase(userId)
+	if err != nil {
+		log.Printf("err: %v\n", err)
+	}
+
+	answerOptions = user.AnswerOptions
+	if _, ok := answerLayouts[answerOptions]; !ok {
+		answerOptions = defaultAnswerOptions
+	}
+
+	return user.QuizMode == quizModeTyping, answerOptions
+}
+
+func nextQuestion(bot *tgbotapi.BotAPI, userId int, callbackQueryData string) {
+
+	session := sessions.Get(userId)
+	forReview := session.ForReview
+	index := session.Index
 
 	if len(forReview) > 0 {
 
 		if index < len(forReview) {
 
-			if err := showAnswerKeybord(bot, userId); err != nil {
+			if session.Typing {
+				if err := showTypingQuestion(bot, userId); err != nil {
+					log.Panic(err)
+				}
+			} else if err := showAnswerKeybord(bot, userId); err != nil {
 				log.Panic(err)
 			}
+			setState(userId, InQuiz)
 
 			// Read quality of answer with usin stopwatch
 			quality := readQuality(userId, callbackQueryData)
 
 			if index > 0 {
-				forReview[index-1].Assess(quality)
+				assessAnswer(userId, index-1, quality, callbackQueryData)
 			}
 
-			indexForReview[userId]++
+			sessions.Update(userId, func(session *Session) {
+				session.Index++
+			})
+			saveQuizSession(userId)
+
+		} else if index == len(forReview) && session.Practice {
+
+			// Practice is over, progress stays untouched
+			quality := readQuality(userId, callbackQueryData)
+			assessAnswer(userId, index-1, quality, callbackQueryData)
+			sessions.Update(userId, func(session *Session) {
+				session.Index = 0
+				session.Stopwatch = Stopwatch{}
+				session.Practice = false
+			})
+			setState(userId, Idle)
+			forgetQuizSession(userId)
+
+			showMessage(bot, userId, "Finished!\n"+quizSummary(userId))
+			showMainMeny(bot, userId)
 
 		} else if index == len(forReview) {
 
 			// Read last update
 			quality := readQuality(userId, callbackQueryData)
-			forReview[index-1].Assess(quality)
-
-			// Nullify variables
-			indexForReview[userId] = 0
-			stopwatch[userId] = Stopwatch{}
+			assessAnswer(userId, index-1, quality, callbackQueryData)
+			forReview = sessions.Get(userId).ForReview
 
 			// Dump facts into base
-			factSet := convertToFactSet(&forReview)
-			if err := updateFactsInBase(userId, &factSet); err != nil {
+			if err := updateFactsInBase(userId, &forReview); err != nil {
 				log.Printf("err: %v\n", err.Error())
 			}
 
-			// Update library for review
-			libraryForReview[userId] = forReview.ForReview()
+			// Nullify variables and update facts for review
+			forReview = forReview.ForReview(userNow(userId))
+			sessions.Update(userId, func(session *Session) {
+				session.ForReview = forReview
+				session.QuizID = newQuizID()
+				session.Index = 0
+				session.Stopwatch = Stopwatch{}
+			})
 
 			// Run nextQustion
-			if len(libraryForReview[userId]) > 1 {
+			if len(forReview) > 1 {
 				nextQuestion(bot, userId, callbackQueryData)
 			} else {
-				showMessage(bot, userId, "Finished!")
+				setState(userId, Idle)
+				forgetQuizSession(userId)
+				showMessage(bot, userId, "Finished!\n"+quizSummary(userId))
 				showMainMeny(bot, userId)
 			}
 		}
 
 	} else {
 
-		showMessage(bot, userId, "Nothing for repetition today! Try Hot20.")
+		setState(userId, Idle)
+		forgetQuizSession(userId)
+		if session.Practice {
+			showMessage(bot, userId, "No cards to practice yet.")
+		} else {
+			showMessage(bot, userId, "Nothing for repetition today! Try Hot20.\n"+dailySummary(userId))
+		}
 	}
 }
 
-func readQuality(userId int, calbackQueryData string) int {
-	sw := stopwatch[userId]
+// Dump already assessed facts and leave quiz before the end
+func stopQuiz(bot *tgbotapi.BotAPI, userId int) {
 
-	if indexForReview[userId] != 0 {
-		sw.mark = time.Since(sw.start)
-		sw.start = time.Now()
+	session := sessions.Get(userId)
 
-	} else {
-		sw.mark = 0
-		sw.start = time.Now()
+	// Question under index-1 is shown but isn't answered yet
+	if !session.Practice && session.Index > 1 {
+		assessed := session.ForReview[:session.Index-1]
+		if err := updateFactsInBase(userId, &assessed); err != nil {
+			log.Printf("err: %v\n", err)
+		}
 	}
 
-	log.Printf("sw.mark: %v\n", sw.mark)
+	sessions.Update(userId, func(session *Session) {
+		session.Index = 0
+		session.Stopwatch = Stopwatch{}
+		session.Practice = false
+	})
+	setState(userId, Idle)
+	forgetQuizSession(userId)
 
-	stopwatch[userId] = sw
+	showMessage(bot, userId, "Stopped.\n"+quizSummary(userId))
+	showMainMeny(bot, userId)
+}
+
+func quizSummary(userId int) string {
+	session := sessions.Get(userId)
+	answered := session.Right + session.Wrong
 
-	if calbackQueryData == "correctAnswer" {
-		if sw.mark.Seconds() < 5 {
-			quality[userId] = 5
-		} else if sw.mark.Seconds() > 5 && sw.mark.Seconds() < 10 {
-			quality[userId] = 4
-		} else if sw.mark.Seconds() > 10 {
-			quality[userId] = 3
+	return fmt.Sprintf("Answered: %d\nRight: %d\nWrong: %d\n", answered, session.Right, session.Wrong) + dailySummary(userId)
+}
+
+// Assess answered fact of session and write answer into review log,
+// answers of practice don't count for daily limits
+func assessAnswer(userId int, index int, quality int, result string) {
+
+	// Fact is changed in store, so concurrent handlers don't see half assessed fact
+	now := userNow(userId)
+	var session Session
+	var before Fact
+	var fact Fact
+	assessed := false
+	sessions.Update(userId, func(stored *Session) {
+		if index < 0 || index >= len(stored.ForReview) {
+			return
 		}
+		before = stored.ForReview[index]
+		stored.ForReview[index].Assess(quality, now)
+		fact = stored.ForReview[index]
+		session = stored.copy()
+		assessed = true
+	})
+	if !assessed {
+		return
+	}
 
-	} else if calbackQueryData == "incorrectAnswer" {
-		if sw.mark.Seconds() < 5 {
-			quality[userId] = 2
-		} else if sw.mark.Seconds() > 5 {
-			quality[userId] = 1
+	if !session.Practice {
+		if err := countAnswerInBase(userId, &before); err != nil {
+			log.Printf("err: %v\n", err)
 		}
+	}
+
+	review := Review{
+		UserID:       userId,
+		DictionaryID: fact.DictionaryID,
+		FactID:       fact.ID,
+		Reversed:     fact.Reversed,
+		Scheduler:    fact.Scheduler,
+		Answer:       session.Answer,
+		Result:       result,
+		Correct:      result == "correctAnswer" || result == "nearAnswer",
+		ResponseTime: session.Stopwatch.mark.Milliseconds(),
+		Quality:      quality,
+		Practice:     session.Practice,
+		Before:       before.FactMetadata,
+		After:        fact.FactMetadata,
+		Date:         time.Now(),
+	}
+	if err := dumpReviewToBase(&review); err != nil {
+		log.Printf("err: %v\n", err)
+	}
+}
 
-	} else if calbackQueryData == "blackout" {
-		quality[userId] = 0
+func startHot20(bot *tgbotapi.BotAPI, user *tgbotapi.User) {
+
+	factSet, err := loadFactsFromBase(user)
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return
+	}
+
+	// Pick random facts regardless of their review date
+	size := hot20Size
+	if len(factSet) < size {
+		size = len(factSet)
+	}
+	hot20 := make(FactSet, size)
+	for i, j := range rand.Perm(len(factSet))[:size] {
+		hot20[i] = factSet[j]
 	}
+	typing, answerOptions := loadQuizSettings(user.ID)
+	sessions.Update(user.ID, func(session *Session) {
+		session.ForReview = hot20
+		session.Cards = factSet
+		session.QuizID = newQuizID()
+		session.Index = 0
+		session.Practice = true
+		session.Typing = typing
+		session.AnswerOptions = answerOptions
+		session.Stopwatch = Stopwatch{}
+		session.Right = 0
+		session.Wrong = 0
+	})
+
+	nextQuestion(bot, user.ID, "hot20")
+}
+
+func readQuality(userId int, calbackQueryData string) (quality int) {
+
+	sessions.Update(userId, func(session *Session) {
+		sw := session.Stopwatch
+
+		if session.Index != 0 {
+			sw.mark = time.Since(sw.start)
+			sw.start = time.Now()
+
+		} else {
+			sw.mark = 0
+			sw.start = time.Now()
+		}
+
+		log.Printf("sw.mark: %v\n", sw.mark)
+
+		session.Stopwatch = sw
+
+		if calbackQueryData == "correctAnswer" {
+			if sw.mark.Seconds() < 5 {
+				session.Quality = 5
+			} else if sw.mark.Seconds() > 5 && sw.mark.Seconds() < 10 {
+				session.Quality = 4
+			} else if sw.mark.Seconds() > 10 {
+				session.Quality = 3
+			}
+
+		} else if calbackQueryData == "nearAnswer" {
+			// Typed with typo, correct response recalled with difficulty
+			session.Quality = 3
+
+		} else if calbackQueryData == "incorrectAnswer" {
+			if sw.mark.Seconds() < 5 {
+				session.Quality = 2
+			} else if sw.mark.Seconds() > 5 {
+				session.Quality = 1
+			}
+
+		} else if calbackQueryData == "blackout" {
+			session.Quality = 0
+		}
+
+		// First question of the round isn't an answer
+		if session.Index != 0 {
+			if calbackQueryData == "correctAnswer" || calbackQueryData == "nearAnswer" {
+				session.Right++
+			} else {
+				session.Wrong++
+			}
+		}
 
-	return quality[userId]
+		quality = session.Quality
+	})
+
+	return quality
 }
 
+// Default timezone for users who haven't chosen own one
 var location, _ = time.LoadLocation("Europe/Kiev")
-var scheduler = *gocron.NewScheduler(location)
 
-func setAllReminds(bot *tgbotapi.BotAPI) {
+var reminderService *ReminderService
+
+// Jobs are kept in base, so they are only synced with users here. Unchanged jobs keep their due time,
+// so restart doesn't move or repeat reminders
+func startReminders(bot *tgbotapi.BotAPI) {
+
+	reminderService = newReminderService(bot)
 
-	var err error
-	remindsChart, err = loadAllRemindsFromBase()
+	reminds, err := loadAllRemindsFromBase()
 	if err != nil {
 		log.Panic(err)
 	}
+	for userId, reminders := range reminds {
+		if err = reminderService.Set(userId, userLocation(userId), reminders); err != nil {
+			log.Printf("err: %v\n", err)
+		}
+	}
 
-	scheduler.Stop()
-	scheduler.Clear()
+	reminderService.Start()
+}
 
-	for userId, remindTime := range remindsChart {
-		scheduler.Every(1).Day().Tag().At(remindTime).Do(showRemind, *bot, int64(userId))
+// Reschedule jobs of one user after reminders or timezone are changed
+func updateReminds(userId int) {
+
+	user, err := loadUserFromBase(userId)
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return
 	}
 
-	scheduler.StartAsync()
+	if err = reminderService.Set(userId, userLocation(userId), scheduledReminders(user)); err != nil {
+		log.Printf("err: %v\n", err)
+	}
 }
 
+// Reminder is skipped if nothing is due or user has answered today already
 func showRemind(bot tgbotapi.BotAPI, userId int64) {
 
-	msg := tgbotapi.NewMessage(userId, "Time to go! Press `Quiz.`")
+	count, err := loadDailyCountFromBase(int(userId))
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return
+	}
+	if count.NewCards+count.Reviews > 0 {
+		return
+	}
+
+	_, forReview, err := loadQuizFromBase(int(userId))
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return
+	}
+	if len(forReview) == 0 {
+		return
+	}
+
+	text := fmt.Sprintf("Time to go! %d cards due, ~%d min.", len(forReview), estimateReviewMinutes(int(userId), len(forReview)))
+	msg := tgbotapi.NewMessage(userId, text)
+	msg.ReplyMarkup = remindKeyboard
 	if _, err := bot.Send(msg); err != nil {
-		log.Panic(err)
+		log.Printf("err: %v\n", err)
+	}
+}
+
+// Late evening nudge, only if streak would be broken by today
+func showStreakRemind(bot tgbotapi.BotAPI, userId int64) {
+
+	streak, err := streakAtRisk(int(userId))
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return
+	}
+	if streak == 0 {
+		return
+	}
+
+	text := fmt.Sprintf("Your streak of %d days ends today. A few cards keep it going.", streak)
+	msg := tgbotapi.NewMessage(userId, text)
+	msg.ReplyMarkup = remindKeyboard
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("err: %v\n", err)
 	}
 }
 
@@ -577,24 +1238,25 @@ Done:
 * TODO Add taking id for each dump function
 * TODO Add returning id into setDefDictInBase and dell getDefDict
 * TODO Add to organize function deleting user dicts if more than three
+* TODO Replace organize function with dictionary manager and quotas
+* TODO Add function for chouse location
 * TODO Add function chouse dictionary - need to be repaired
 * TODO Add showing available dictionaries for user (and public and his private)
 * TODO Add correct answer into each callback message
 * TODO Fix issue with multi scheduling
 * TODO Fix issue with default dictionary
+* TODO Add function for download dictionary
+* TODO Unite all map in one map or struct
+* TODO Add stop key into Quiz
+* TODO Add blackout key into Quiz
+* TODO Add posibiliti for pickDictionary pick private dict without copying
 
 In work:
 * TODO Create two environement, prod and staging
 
 In plan:
 * TODO Create helm chart and helmfile
-* TODO Add function for download dictionary
-* TODO Add posibiliti for pickDictionary pick private dict without copying
 * TODO Add exeptions into time handler
-* TODO Unite all map in one map or struct
-* TODO Add stop key into Quiz
-* TODO Add blackout key into Quiz
-* TODO Add function for chouse location
 * TODO Rewrite all messages wih html
 * TODO Add functionalyty for send message to creator in error situation
 * TODO Move it up, all hardcoded strings
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Progress of one user on one card, dictionary content is stored once in library
+type Progress struct {
+	UserID       int                `bson:"userId"`
+	DictionaryID primitive.ObjectID `bson:"dictionaryId"`
+	FactID       primitive.ObjectID `bson:"factId"`
+	Reversed     bool               `bson:"reversed"`
+	FactMetadata FactMetadata       `bson:"factMetadata"`
+}
+
+type progressKey struct {
+	FactID   primitive.ObjectID
+	Reversed bool
+}
+
+func createProgressIndex() error {
+
+	_, err := progressCollection.Indexes().CreateOne(
+		context.TODO(),
+		mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "userId", Value: 1},
+				{Key: "dictionaryId", Value: 1},
+				{Key: "factId", Value: 1},
+				{Key: "reversed", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+
+	return err
+}
+
+func loadProgressFromBase(userId int, dictionaryId primitive.ObjectID) (map[progressKey]FactMetadata, error) {
+
+	cursor, err := progressCollection.Find(context.TODO(), bson.M{"userId": userId, "dictionaryId": dictionaryId})
+	if err != nil {
+		return nil, err
+	}
+
+	var progresses []Progress
+	if err = cursor.All(context.TODO(), &progresses); err != nil {
+		return nil, err
+	}
+
+	progress := map[progressKey]FactMetadata{}
+	for _, p := range progresses {
+		progress[progressKey{p.FactID, p.Reversed}] = p.FactMetadata
+	}
+
+	return progress, nil
+}
+
+// Put user progress into dictionary content, facts without progress are new ones
+func joinProgress(dictionary *Dictionary, progress map[progressKey]FactMetadata) {
+
+	for i := range dictionary.FactSet {
+		fact := &dictionary.FactSet[i]
+		fact.DictionaryID = dictionary.ID
+		fact.FactMetadata = progress[progressKey{fact.ID, false}]
+		fact.Reverse = progress[progressKey{fact.ID, true}]
+		upgradeFactMetadata(&fact.FactMetadata)
+		upgradeFactMetadata(&fact.Reverse)
+	}
+}
+
+func updateFactsInBase(userId int, factSet *FactSet) error {
+	for _, fact := range *factSet {
+
+		_, err := progressCollection.UpdateOne(
+			context.TODO(),
+			bson.M{"userId": userId, "dictionaryId": fact.DictionaryID, "factId": fact.ID, "reversed": fact.Reversed},
+			bson.M{"$set": bson.M{"factMetadata": fact.FactMetadata}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Facts read from six-column csv carry progress, new facts don't need it
+func hasProgress(metadata FactMetadata) bool {
+	return metadata.N > 0 || metadata.Interval > 0 || (metadata.Ef != 0 && metadata.Ef != 2.5)
+}
+
+func dumpImportedProgressToBase(userId int, dictionary *Dictionary) error {
+
+	var factSet FactSet
+	for _, fact := range dictionary.FactSet {
+		fact.DictionaryID = dictionary.ID
+		if hasProgress(fact.FactMetadata) {
+			factSet = append(factSet, fact)
+		}
+		if hasProgress(fact.Reverse) {
+			reverse := fact
+			reverse.FactMetadata = fact.Reverse
+			reverse.Reversed = true
+			factSet = append(factSet, reverse)
+		}
+	}
+
+	return updateFactsInBase(userId, &factSet)
+}
+
+// Before progress was split from content every user had own copy of dictionary,
+// copies of public dictionaries are replaced by progress and pointer to the original
+func migrateUserCopiesInBase() error {
+
+	cursor, err := libraryCollection.Find(context.TODO(), bson.M{"dictionaryMetadata.ownerId": bson.M{"$ne": 0}})
+	if err != nil {
+		return err
+	}
+
+	var dictionaries []Dictionary
+	if err = cursor.All(context.TODO(), &dictionaries); err != nil {
+		return err
+	}
+
+	migrated := 0
+	// Facts with progress which aren't in original anymore, their progress is lost
+	unmatched := 0
+	for _, dictionary := range dictionaries {
+		userId := dictionary.DictionaryMetadata.OwnerID
+		current := dictionary.DictionaryMetadata.Status == "current"
+
+		// User has pointer to dictionary which is migrated already
+		userDictionaries, err := loadUserDictionariesFromBase(userId)
+		if err != nil {
+			return err
+		}
+		if findUserDictionary(userDictionaries, dictionary.ID) != nil {
+			continue
+		}
+
+		// Copies have id of source dictionary in file path, pushed ones have real path.
+		// Sources are reloaded on each start, so original is found by name
+		var original Dictionary
+		if primitive.IsValidObjectID(dictionary.DictionaryMetadata.FilePath) {
+			err = libraryCollection.FindOne(
+				context.TODO(),
+				bson.M{"dictionaryMetadata.name": dictionary.DictionaryMetadata.Name, "dictionaryMetadata.status": bson.M{"$in": []string{"library", "default"}}},
+			).Decode(&original)
+			if err == mongo.ErrNoDocuments {
+				log.Printf("Original of dictionary %v isn't found, copy is kept as pushed one\n", dictionary.ID.Hex())
+			} else if err != nil {
+				return err
+			}
+		}
+
+		// Pushed dictionary and copy without original stay in library as private ones of user
+		if original.ID.IsZero() {
+			dictionary.FactSet.setDictionaryID(dictionary.ID)
+			if err = migrateProgress(userId, dictionary.FactSet); err != nil {
+				return err
+			}
+			if err = addUserDictionaryInBase(userId, dictionary.ID, current); err != nil {
+				return err
+			}
+			if err = setDictionaryMetaInBase(&dictionary.ID, DictionaryMetadata{Status: "private"}); err != nil {
+				return err
+			}
+			migrated++
+			continue
+		}
+
+		var factSet FactSet
+		for _, fact := range matchOriginalFacts(dictionary.FactSet, original.FactSet) {
+			if !fact.ID.IsZero() {
+				fact.DictionaryID = original.ID
+				factSet = append(factSet, fact)
+			} else if hasProgress(fact.FactMetadata) || hasProgress(fact.Reverse) {
+				log.Printf("Fact %q of user %d isn't found in dictionary %v, its progress is dropped\n", fact.Question, userId, original.ID.Hex())
+				unmatched++
+			}
+		}
+
+		if err = migrateProgress(userId, factSet); err != nil {
+			return err
+		}
+		if err = addUserDictionaryInBase(userId, original.ID, current); err != nil {
+			return err
+		}
+		if _, err = libraryCollection.DeleteOne(context.TODO(), bson.M{"_id": dictionary.ID}); err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	log.Printf("Dictionaries migrated to progress: %v, facts with dropped progress: %v\n", migrated, unmatched)
+	return nil
+}
+
+// Facts of copy get ids of the same facts in original, copies made after facts got ids keep them.
+// Other facts are matched like facts of reloaded dictionary, see keepIDs. Facts without match have zero id
+func matchOriginalFacts(copied FactSet, original FactSet) FactSet {
+
+	originalIDs := map[primitive.ObjectID]bool{}
+	for _, fact := range original {
+		originalIDs[fact.ID] = true
+	}
+
+	matched := make(FactSet, len(copied))
+	copy(matched, copied)
+	used := map[primitive.ObjectID]bool{}
+	for i := range matched {
+		if originalIDs[matched[i].ID] && !used[matched[i].ID] {
+			used[matched[i].ID] = true
+		} else {
+			matched[i].ID = primitive.NilObjectID
+		}
+	}
+
+	var rest FactSet
+	for _, fact := range original {
+		if !used[fact.ID] {
+			rest = append(rest, fact)
+		}
+	}
+	matched.keepIDs(rest)
+
+	return matched
+}
+
+// Baseline gave review date to every new fact, so only real answers are taken as progress
+func migrateProgress(userId int, factSet FactSet) error {
+
+	var progress FactSet
+	for _, fact := range factSet {
+		if hasProgress(fact.FactMetadata) {
+			progress = append(progress, fact)
+		}
+		if hasProgress(fact.Reverse) {
+			progress = append(progress, Fact{ID: fact.ID, DictionaryID: fact.DictionaryID, FactMetadata: fact.Reverse, Reversed: true})
+		}
+	}
+
+	return updateFactsInBase(userId, &progress)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatchOriginalFacts(t *testing.T) {
+
+	original := FactSet{
+		{ID: primitive.NewObjectID(), Question: "cat", Answer: "кіт"},
+		{ID: primitive.NewObjectID(), Question: "dog", Answer: "пес"},
+		{ID: primitive.NewObjectID(), Question: "house", Answer: "будинок"},
+		{ID: primitive.NewObjectID(), Question: "run", Answer: "бігти"},
+	}
+	copied := FactSet{
+		// Copy made after facts got ids, question is fixed in original since
+		{ID: original[0].ID, Question: "kat", Answer: "кіт"},
+		// Old copies have no ids or ids of their own
+		{Question: "dog", Answer: "пес"},
+		{ID: primitive.NewObjectID(), Question: "house", Answer: "дім"},
+		// Same question twice doesn't take id of one fact twice
+		{Question: "dog", Answer: "собака"},
+		{Question: "removed", Answer: "видалене"},
+	}
+
+	want := []primitive.ObjectID{original[0].ID, original[1].ID, original[2].ID, primitive.NilObjectID, primitive.NilObjectID}
+	matched := matchOriginalFacts(copied, original)
+	for i := range want {
+		if matched[i].ID != want[i] {
+			t.Errorf("%q: got id %v, want %v", copied[i].Question, matched[i].ID.Hex(), want[i].Hex())
+		}
+	}
+
+	if copied[2].ID.IsZero() {
+		t.Errorf("ids of copy are changed")
+	}
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Actions of reminders screen, callback data is like "remDel|20:00|mon,wed" or "remDays|<hour>|<minute>|<days>"
+const (
+	remindersAction      = "reminders"
+	addReminderAction    = "remAdd"
+	deleteReminderAction = "remDel"
+	reminderHourAction   = "remHour"
+	reminderMinuteAction = "remMin"
+	reminderDaysAction   = "remDays"
+	streakReminderAction = "remStreak"
+)
+
+var maxReminders = 5
+
+var errTooManyReminders = fmt.Errorf("no more than %d reminders", maxReminders)
+
+// Minutes offered by time picker, any other minute can be typed
+var reminderMinutes = []int{0, 15, 30, 45}
+
+// Time of streak nudge in user timezone, late enough to review before midnight
+var streakReminderTime = "21:30"
+
+// Used for time estimate until user has own answers
+var defaultSecondsPerCard = 8
+
+var remindKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+	tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Quiz", "quiz"),
+	),
+)
+
+var reminderFormatHelp = "Send time like 20:00, 8pm or 8:30 am, days can follow: mon,wed or weekdays."
+
+// Reminder is time of day in user timezone, on some weekdays or every day
+type Reminder struct {
+	// HH:MM, see parseReminder
+	Time string `bson:"time"`
+	// Empty is every day
+	Weekdays []time.Weekday `bson:"weekdays,omitempty"`
+	// Streak nudge, it isn't stored, see User.StreakReminder
+	Streak bool `bson:"-"`
+}
+
+func (reminder Reminder) String() string {
+
+	if len(reminder.Weekdays) == 0 {
+		return reminder.Time + " every day"
+	}
+
+	days := make([]string, len(reminder.Weekdays))
+	for i, weekday := range reminder.Weekdays {
+		days[i] = weekday.String()[:3]
+	}
+
+	return reminder.Time + " " + strings.Join(days, ", ")
+}
+
+// Time and days like "20:00|mon,wed" or "20:00|daily", it is read back by parseReminderValue
+func (reminder Reminder) Value() string {
+
+	if len(reminder.Weekdays) == 0 {
+		return reminder.Time + "|daily"
+	}
+
+	days := make([]string, len(reminder.Weekdays))
+	for i, weekday := range reminder.Weekdays {
+		days[i] = strings.ToLower(weekday.String()[:3])
+	}
+
+	return reminder.Time + "|" + strings.Join(days, ",")
+}
+
+// Key tells reminders apart, streak nudge isn't the same as usual reminder at the same time
+func (reminder Reminder) Key() string {
+
+	if reminder.Streak {
+		return "streak|" + reminder.Time
+	}
+
+	return reminder.Value()
+}
+
+func parseReminderValue(value string) (Reminder, error) {
+	return parseReminder(strings.Replace(value, "|", " ", 1))
+}
+
+var (
+	weekdaysOnly = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	weekendsOnly = []time.Weekday{time.Saturday, time.Sunday}
+)
+
+// Names of days and groups of days, days are named in full or by three letters
+var weekdayNames = map[string][]time.Weekday{
+	"mon":       {time.Monday},
+	"tue":       {time.Tuesday},
+	"wed":       {time.Wednesday},
+	"thu":       {time.Thursday},
+	"fri":       {time.Friday},
+	"sat":       {time.Saturday},
+	"sun":       {time.Sunday},
+	"monday":    {time.Monday},
+	"tuesday":   {time.Tuesday},
+	"wednesday": {time.Wednesday},
+	"thursday":  {time.Thursday},
+	"friday":    {time.Friday},
+	"saturday":  {time.Saturday},
+	"sunday":    {time.Sunday},
+	"weekdays":  weekdaysOnly,
+	"weekends":  weekendsOnly,
+	"daily":     nil,
+}
+
+var reminderPattern = regexp.MustCompile(`(?i)^\s*(\d{1,2})(?:[:.](\d{2}))?\s*(am|pm)?\s*(.*)$`)
+
+// Parse text like "20:00", "8pm", "8:30 am" or "7:15 mon,wed"
+func parseReminder(text string) (Reminder, error) {
+
+	var reminder Reminder
+
+	match := reminderPattern.FindStringSubmatch(text)
+	if match == nil {
+		return reminder, fmt.Errorf("bad reminder time %q", text)
+	}
+
+	hour, _ := strconv.Atoi(match[1])
+	minute := 0
+	if match[2] != "" {
+		minute, _ = strconv.Atoi(match[2])
+	}
+
+	switch strings.ToLower(match[3]) {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return reminder, fmt.Errorf("bad reminder hour %q", text)
+		}
+		hour %= 12
+		if strings.ToLower(match[3]) == "pm" {
+			hour += 12
+		}
+	default:
+		if hour > 23 {
+			return reminder, fmt.Errorf("bad reminder hour %q", text)
+		}
+	}
+	if minute > 59 {
+		return reminder, fmt.Errorf("bad reminder minute %q", text)
+	}
+	reminder.Time = fmt.Sprintf("%02d:%02d", hour, minute)
+
+	weekdays, err := parseWeekdays(match[4])
+	if err != nil {
+		return reminder, err
+	}
+	reminder.Weekdays = weekdays
+
+	return reminder, nil
+}
+
+// Days are separated by commas or spaces, result is ordered from Monday, empty is every day
+func parseWeekdays(text string) ([]time.Weekday, error) {
+
+	chosen := map[time.Weekday]bool{}
+	everyDay := false
+	for _, name := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return r == ',' || r == ' ' }) {
+		days, ok := weekdayNames[name]
+		if !ok {
+			return nil, fmt.Errorf("bad weekday %q", name)
+		}
+		if days == nil {
+			everyDay = true
+		}
+		for _, day := range days {
+			chosen[day] = true
+		}
+	}
+
+	if everyDay || len(chosen) == 7 {
+		return nil, nil
+	}
+
+	var weekdays []time.Weekday
+	for _, day := range append(weekdaysOnly, weekendsOnly...) {
+		if chosen[day] {
+			weekdays = append(weekdays, day)
+		}
+	}
+
+	return weekdays, nil
+}
+
+// Reminders of user, single reminder time of old versions is used until reminders are edited
+func userReminders(user User) []Reminder {
+
+	if len(user.Reminders) > 0 {
+		return user.Reminders
+	}
+	if user.ReminderTime == "" {
+		return nil
+	}
+
+	reminder, err := parseReminder(user.ReminderTime)
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return nil
+	}
+
+	return []Reminder{reminder}
+}
+
+// Reminders with streak nudge, they are jobs of reminder service
+func scheduledReminders(user User) []Reminder {
+
+	reminders := userReminders(user)
+	if user.StreakReminder {
+		reminders = append(reminders, Reminder{Time: streakReminderTime, Streak: true})
+	}
+
+	return reminders
+}
+
+func loadAllRemindsFromBase() (map[int][]Reminder, error) {
+	reminds := map[int][]Reminder{}
+	users, err := usersCollection.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	for users.Next(context.TODO()) {
+		var user User
+		if err = users.Decode(&user); err != nil {
+			return nil, err
+		}
+
+		if reminders := scheduledReminders(user); len(reminders) > 0 {
+			reminds[user.User.ID] = reminders
+		}
+	}
+
+	return reminds, nil
+}
+
+func loadRemindersFromBase(userId int) ([]Reminder, error) {
+
+	user, err := loadUserFromBase(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return userReminders(user), nil
+}
+
+// Old reminder time is dropped, it is in the list already
+func dumpRemindersToBase(userId int, reminders []Reminder) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{
+			"$set":   bson.M{"reminders": reminders},
+			"$unset": bson.M{"reminder_time": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Same reminder isn't added twice
+func addReminderInBase(userId int, reminder Reminder) error {
+
+	reminders, err := loadRemindersFromBase(userId)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range reminders {
+		if r.String() == reminder.String() {
+			return nil
+		}
+	}
+	if len(reminders) >= maxReminders {
+		return errTooManyReminders
+	}
+
+	return dumpRemindersToBase(userId, append(reminders, reminder))
+}
+
+func dumpStreakReminderToBase(userId int, streakReminder bool) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$set": bson.M{"streak_reminder": streakReminder}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Reminder is found by its value, so screen shown before other changes deletes the right one
+func deleteReminderFromBase(userId int, reminder Reminder) error {
+
+	reminders, err := loadRemindersFromBase(userId)
+	if err != nil {
+		return err
+	}
+
+	var kept []Reminder
+	for _, r := range reminders {
+		if r.Value() != reminder.Value() {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(reminders) {
+		return nil
+	}
+
+	return dumpRemindersToBase(userId, kept)
+}
+
+// Minutes for answering cards, by average answer time of last days
+func estimateReviewMinutes(userId int, cards int) int {
+
+	seconds := float64(defaultSecondsPerCard)
+	reviews, err := loadReviewsFromBase(userId, time.Now().AddDate(0, 0, -statsDays))
+	if err != nil {
+		log.Printf("err: %v\n", err)
+	} else if len(reviews) > 0 {
+		var responseTime int64
+		for _, review := range reviews {
+			responseTime += review.ResponseTime
+		}
+		seconds = float64(responseTime) / float64(len(reviews)) / 1000
+	}
+
+	return int(math.Max(1, math.Ceil(float64(cards)*seconds/60)))
+}
+
+// Length of streak which ends today if user doesn't answer, 0 if user has answered or has no streak
+func streakAtRisk(userId int) (int, error) {
+
+	now := userNow(userId)
+	reviews, err := loadReviewsFromBase(userId, now.AddDate(0, 0, -statsDays))
+	if err != nil {
+		return 0, err
+	}
+
+	days := map[string]int{}
+	for _, review := range reviews {
+		days[review.Date.In(now.Location()).Format("2006-01-02")]++
+	}
+	if days[now.Format("2006-01-02")] > 0 {
+		return 0, nil
+	}
+
+	return streakDays(days, now), nil
+}
+
+func remindersKeyboard(userId int) (string, tgbotapi.InlineKeyboardMarkup, error) {
+
+	user, err := loadUserFromBase(userId)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	reminders := userReminders(user)
+
+	text := "Your reminders, time is " + userLocation(userId).String() + ".\nPress reminder to delete it."
+	if len(reminders) == 0 {
+		text = "You have no reminders, time is " + userLocation(userId).String() + "."
+	}
+	text += "\nReminder is sent only if you have cards due and haven't answered today." +
+		"\nStreak reminder comes at " + streakReminderTime + " if your streak is going to end."
+	text += "\nTo add reminder " + strings.ToLower(reminderFormatHelp[:1]) + reminderFormatHelp[1:]
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+	for _, reminder := range reminders {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ "+reminder.String(), deleteReminderAction+"|"+reminder.Value())))
+	}
+	if len(reminders) < maxReminders {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Add reminder", addReminderAction)))
+	}
+	streakText := "Streak reminder: off"
+	if user.StreakReminder {
+		streakText = "Streak reminder: on"
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(streakText, streakReminderAction)))
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", "backToSettings")))
+
+	return text, keyboard, nil
+}
+
+// Typed time is accepted while reminders screen is shown
+func showReminders(bot *tgbotapi.BotAPI, userId int) error {
+
+	text, keyboard, err := remindersKeyboard(userId)
+	if err != nil {
+		return err
+	}
+
+	setState(userId, AwaitingReminderTime)
+	msg := tgbotapi.NewMessage(int64(userId), text)
+	msg.ReplyMarkup = keyboard
+	if _, err = bot.Send(msg); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func reminderHourKeyboard() tgbotapi.InlineKeyboardMarkup {
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup()
+	for hour := 0; hour < 24; hour += 6 {
+		var row []tgbotapi.InlineKeyboardButton
+		for h := hour; h < hour+6; h++ {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%02d", h), reminderHourAction+"|"+strconv.Itoa(h)))
+		}
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", remindersAction)))
+
+	return keyboard
+}
+
+func reminderMinuteKeyboard(hour int) tgbotapi.InlineKeyboardMarkup {
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, minute := range reminderMinutes {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%02d:%02d", hour, minute),
+			reminderMinuteAction+"|"+strconv.Itoa(hour)+"|"+strconv.Itoa(minute)))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("<< Back", addReminderAction)))
+}
+
+func reminderDaysKeyboard(hour, minute int) tgbotapi.InlineKeyboardMarkup {
+
+	data := reminderDaysAction + "|" + strconv.Itoa(hour) + "|" + strconv.Itoa(minute) + "|"
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Every day", data+"daily"),
+			tgbotapi.NewInlineKeyboardButtonData("Weekdays", data+"weekdays"),
+			tgbotapi.NewInlineKeyboardButtonData("Weekends", data+"weekends"),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("<< Back", reminderHourAction+"|"+strconv.Itoa(hour))),
+	)
+}
+
+func isReminderCallback(data string) bool {
+
+	action := strings.Split(data, "|")[0]
+	switch action {
+	case remindersAction, addReminderAction, streakReminderAction, deleteReminderAction, reminderHourAction, reminderMinuteAction, reminderDaysAction:
+		return true
+	}
+
+	return false
+}
+
+// Buttons of time picker, numbers in callback data are made by keyboards above
+func handleReminderCallback(bot *tgbotapi.BotAPI, callbackQuery *tgbotapi.CallbackQuery) error {
+
+	userId := callbackQuery.From.ID
+	parts := strings.Split(callbackQuery.Data, "|")
+	numbers := make([]int, 0, 2)
+	for _, part := range parts[1:] {
+		if number, err := strconv.Atoi(part); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+
+	switch parts[0] {
+	case addReminderAction:
+		editDictionaryScreen(bot, callbackQuery, "Pick hour:", reminderHourKeyboard())
+		return nil
+
+	case reminderHourAction:
+		if len(numbers) < 1 {
+			return fmt.Errorf("bad reminder callback %q", callbackQuery.Data)
+		}
+		editDictionaryScreen(bot, callbackQuery, "Pick minute:", reminderMinuteKeyboard(numbers[0]))
+		return nil
+
+	case reminderMinuteAction:
+		if len(numbers) < 2 {
+			return fmt.Errorf("bad reminder callback %q", callbackQuery.Data)
+		}
+		editDictionaryScreen(bot, callbackQuery, fmt.Sprintf("Remind at %02d:%02d on:", numbers[0], numbers[1]), reminderDaysKeyboard(numbers[0], numbers[1]))
+		return nil
+
+	case reminderDaysAction:
+		if len(numbers) < 2 || len(parts) < 4 {
+			return fmt.Errorf("bad reminder callback %q", callbackQuery.Data)
+		}
+		reminder, err := parseReminder(fmt.Sprintf("%02d:%02d %s", numbers[0], numbers[1], parts[3]))
+		if err == nil {
+			err = addReminderInBase(userId, reminder)
+		}
+		if err != nil {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callbackQuery.ID, addReminderFailure(err)))
+			return err
+		}
+		updateReminds(userId)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackQuery.ID, "Reminder is set: "+reminder.String()))
+
+	case streakReminderAction:
+		user, err := loadUserFromBase(userId)
+		if err != nil {
+			return err
+		}
+		if err = dumpStreakReminderToBase(userId, !user.StreakReminder); err != nil {
+			return err
+		}
+		updateReminds(userId)
+
+	case deleteReminderAction:
+		reminder, err := parseReminderValue(strings.TrimPrefix(callbackQuery.Data, deleteReminderAction+"|"))
+		if err == nil {
+			err = deleteReminderFromBase(userId, reminder)
+		}
+		if err != nil {
+			bot.AnswerCallbackQuery(tgbotapi.NewCallbackWithAlert(callbackQuery.ID, "Can't delete reminder, try again later."))
+			return err
+		}
+		updateReminds(userId)
+		bot.AnswerCallbackQuery(tgbotapi.NewCallback(callbackQuery.ID, "Reminder is deleted."))
+	}
+
+	text, keyboard, err := remindersKeyboard(userId)
+	if err != nil {
+		return err
+	}
+	setState(userId, AwaitingReminderTime)
+	editDictionaryScreen(bot, callbackQuery, text, keyboard)
+
+	return nil
+}
+
+// Typed reminder, user keeps waiting until time is readable
+func addTypedReminder(bot *tgbotapi.BotAPI, userId int, text string) {
+
+	reminder, err := parseReminder(text)
+	if err != nil {
+		showMessage(bot, userId, "Can't read time. "+reminderFormatHelp+" Use /cancel to stop waiting.")
+		return
+	}
+
+	if err = addReminderInBase(userId, reminder); err != nil {
+		log.Printf("err: %v\n", err)
+		showMessage(bot, userId, addReminderFailure(err))
+		return
+	}
+	updateReminds(userId)
+	setState(userId, Idle)
+	showMessage(bot, userId, "Reminder is set: "+reminder.String())
+}
+
+// Only limit of reminders is user fault, other errors are ours
+func addReminderFailure(err error) string {
+
+	if errors.Is(err, errTooManyReminders) {
+		return "Can't add reminder, you have " + strconv.Itoa(maxReminders) + " already."
+	}
+
+	return "Can't add reminder, try again later."
+}
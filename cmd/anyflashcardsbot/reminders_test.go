@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestParseReminder(t *testing.T) {
+
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"20:00", "20:00|daily", false},
+		{"8pm", "20:00|daily", false},
+		{"8:30 am", "08:30|daily", false},
+		{"12am", "00:00|daily", false},
+		{"7:15 mon,wed", "07:15|mon,wed", false},
+		{"7:15 Wednesday Monday", "07:15|mon,wed", false},
+		{"9:00 weekends", "09:00|sat,sun", false},
+		{"9:00 weekdays sat sun", "09:00|daily", false},
+		{"9:00 month", "", true},
+		{"9:00 sundays", "", true},
+		{"9:00 mo", "", true},
+		{"24:00", "", true},
+		{"13pm", "", true},
+		{"10:60", "", true},
+	}
+
+	for _, tt := range tests {
+		reminder, err := parseReminder(tt.text)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: got %q, want error", tt.text, reminder.Value())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+			continue
+		}
+		if reminder.Value() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.text, reminder.Value(), tt.want)
+		}
+
+		// Value is used in callback data and has to be read back
+		parsed, err := parseReminderValue(reminder.Value())
+		if err != nil || parsed.Value() != reminder.Value() {
+			t.Errorf("%q: value %q is read back as %q, %v", tt.text, reminder.Value(), parsed.Value(), err)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reminders are jobs in base with time of the next sending. Every replica of bot checks them, replica
+// which takes due job leases it for a while, so job isn't sent twice by several replicas. If replica
+// stops before job is done, lease is over and job is taken by another one.
+var (
+	reminderPollInterval = 30 * time.Second
+	reminderLease        = 2 * time.Minute
+	// Reminder which is late more than that, e.g. because bot was down, is skipped
+	maxReminderDelay = time.Hour
+)
+
+// ReminderJob is one reminder of user, it is done again and again at DueAt
+type ReminderJob struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	UserID int                `bson:"userId"`
+	// Reminder.Key, user has one job for every reminder
+	Key string `bson:"key"`
+	// Set call which has written the job, see User.ReminderGeneration
+	Generation int64          `bson:"generation"`
+	Time       string         `bson:"time"`
+	Weekdays   []time.Weekday `bson:"weekdays"`
+	Streak     bool           `bson:"streak"`
+	// Zone of Time, IANA name
+	Timezone string    `bson:"timezone"`
+	DueAt    time.Time `bson:"dueAt"`
+	// Process which does the job now, see reminderOwner
+	LeaseOwner string    `bson:"leaseOwner"`
+	LeaseUntil time.Time `bson:"leaseUntil"`
+}
+
+func (job ReminderJob) Reminder() Reminder {
+	return Reminder{Time: job.Time, Weekdays: job.Weekdays, Streak: job.Streak}
+}
+
+// First moment of reminder after the moment, both are in location of after
+func nextReminderTime(reminder Reminder, after time.Time) (time.Time, error) {
+
+	clock, err := time.Parse("15:04", reminder.Time)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	days := map[time.Weekday]bool{}
+	for _, weekday := range reminder.Weekdays {
+		days[weekday] = true
+	}
+
+	// Week and one day, today time can be passed already
+	for day := 0; day <= 7; day++ {
+		date := after.AddDate(0, 0, day)
+		next := time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), 0, 0, after.Location())
+		// Time skipped by change to summer time is moved on by the change, like clocks are
+		if shift := clock.Sub(time.Date(0, 1, 1, next.Hour(), next.Minute(), 0, 0, time.UTC)); shift != 0 {
+			if shift < 0 {
+				shift += 24 * time.Hour
+			}
+			next = next.Add(shift)
+		}
+		if next.After(after) && (len(days) == 0 || days[next.Weekday()]) {
+			return next, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("no time for reminder %v", reminder)
+}
+
+// Pod name in Kubernetes, so leases in base show which pod holds them
+func reminderOwner() string {
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "bot"
+	}
+
+	return hostname + "-" + strconv.Itoa(os.Getpid())
+}
+
+func createRemindersIndexes() error {
+
+	// Jobs of older version were kept by place in list, they are made again by startReminders
+	if _, err := remindersCollection.Indexes().DropOne(context.TODO(), "userId_1_slot_1"); err != nil {
+		log.Printf("Index of reminder slots isn't dropped: %v\n", err)
+	}
+	if _, err := remindersCollection.DeleteMany(context.TODO(), bson.M{"key": bson.M{"$exists": false}}); err != nil {
+		return err
+	}
+
+	_, err := remindersCollection.Indexes().CreateMany(
+		context.TODO(),
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "key", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: bson.D{{Key: "dueAt", Value: 1}, {Key: "leaseUntil", Value: 1}},
+			},
+		},
+	)
+
+	return err
+}
+
+func nextReminderGenerationInBase(userId int) (int64, error) {
+
+	var user User
+	err := usersCollection.FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$inc": bson.M{"reminder_generation": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		return 0, err
+	}
+
+	return user.ReminderGeneration, nil
+}
+
+// Job of older generation is updated, job written by newer Set is left as is. Unchanged job only
+// moves to the generation and keeps its due time, so it isn't sent twice or skipped.
+func putReminderJobInBase(job ReminderJob) error {
+
+	result, err := remindersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"userId": job.UserID, "key": job.Key, "timezone": job.Timezone, "generation": bson.M{"$lte": job.Generation}},
+		bson.M{"$set": bson.M{"generation": job.Generation}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	_, err = remindersCollection.ReplaceOne(
+		context.TODO(),
+		bson.M{"userId": job.UserID, "key": job.Key, "generation": bson.M{"$lte": job.Generation}},
+		job,
+		options.Replace().SetUpsert(true),
+	)
+	// Job of newer generation isn't matched, so upsert hits unique index
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Jobs which aren't written by the last Set of user are removed, even if that Set isn't over yet
+func deleteStaleReminderJobsInBase(userId int) error {
+
+	user, err := loadUserFromBase(userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = remindersCollection.DeleteMany(context.TODO(), bson.M{"userId": userId, "generation": bson.M{"$lt": user.ReminderGeneration}})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Take one due job which isn't leased by other process
+func claimReminderJobInBase(owner string, now time.Time) (ReminderJob, error) {
+
+	var job ReminderJob
+	err := remindersCollection.FindOneAndUpdate(
+		context.TODO(),
+		bson.M{"dueAt": bson.M{"$lte": now}, "leaseUntil": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"leaseOwner": owner, "leaseUntil": now.Add(reminderLease)}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetSort(bson.M{"dueAt": 1}),
+	).Decode(&job)
+
+	return job, err
+}
+
+// Job isn't changed if lease was lost, e.g. user has changed reminders meanwhile
+func completeReminderJobInBase(job ReminderJob, owner string, dueAt time.Time) error {
+
+	_, err := remindersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"_id": job.ID, "leaseOwner": owner},
+		bson.M{"$set": bson.M{"dueAt": dueAt, "leaseOwner": "", "leaseUntil": time.Time{}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Storage of reminder jobs, functions above in base, tests keep jobs in memory
+type reminderJobStore interface {
+	NextGeneration(userId int) (int64, error)
+	Put(job ReminderJob) error
+	DeleteStale(userId int) error
+	Claim(owner string, now time.Time) (ReminderJob, error)
+	Complete(job ReminderJob, owner string, dueAt time.Time) error
+}
+
+type baseReminderJobs struct{}
+
+func (baseReminderJobs) NextGeneration(userId int) (int64, error) {
+	return nextReminderGenerationInBase(userId)
+}
+
+func (baseReminderJobs) Put(job ReminderJob) error {
+	return putReminderJobInBase(job)
+}
+
+func (baseReminderJobs) DeleteStale(userId int) error {
+	return deleteStaleReminderJobsInBase(userId)
+}
+
+func (baseReminderJobs) Claim(owner string, now time.Time) (ReminderJob, error) {
+	return claimReminderJobInBase(owner, now)
+}
+
+func (baseReminderJobs) Complete(job ReminderJob, owner string, dueAt time.Time) error {
+	return completeReminderJobInBase(job, owner, dueAt)
+}
+
+// ReminderService keeps reminder jobs in base and sends reminders which are due. It is safe
+// for concurrent use and for several processes working with the same base.
+type ReminderService struct {
+	bot   *tgbotapi.BotAPI
+	owner string
+	jobs  reminderJobStore
+	// Clock of service, time.Now out of tests
+	now func() time.Time
+}
+
+func newReminderService(bot *tgbotapi.BotAPI) *ReminderService {
+	return &ReminderService{bot: bot, owner: reminderOwner(), jobs: baseReminderJobs{}, now: time.Now}
+}
+
+// Replace jobs of user, unchanged reminders keep their due time, no reminders removes all jobs.
+// Every call gets own generation, when calls for one user overlap the last started one wins.
+func (service *ReminderService) Set(userId int, userLocation *time.Location, reminders []Reminder) error {
+
+	generation, err := service.jobs.NextGeneration(userId)
+	if err != nil {
+		return err
+	}
+
+	now := service.now().In(userLocation)
+	for _, reminder := range reminders {
+		job := ReminderJob{
+			UserID:     userId,
+			Key:        reminder.Key(),
+			Generation: generation,
+			Time:       reminder.Time,
+			Weekdays:   reminder.Weekdays,
+			Streak:     reminder.Streak,
+			Timezone:   userLocation.String(),
+		}
+		if job.Weekdays == nil {
+			job.Weekdays = []time.Weekday{}
+		}
+
+		if job.DueAt, err = nextReminderTime(reminder, now); err != nil {
+			log.Printf("err: %v\n", err)
+			continue
+		}
+		if err = service.jobs.Put(job); err != nil {
+			return err
+		}
+	}
+
+	return service.jobs.DeleteStale(userId)
+}
+
+// Check for due jobs until the end of program
+func (service *ReminderService) Start() {
+
+	go func() {
+		for {
+			service.sendDue()
+			time.Sleep(reminderPollInterval)
+		}
+	}()
+}
+
+func (service *ReminderService) sendDue() {
+
+	for {
+		now := service.now()
+		job, err := service.jobs.Claim(service.owner, now)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Printf("err: %v\n", err)
+			return
+		}
+
+		if now.Sub(job.DueAt) <= maxReminderDelay {
+			if job.Streak {
+				showStreakRemind(*service.bot, int64(job.UserID))
+			} else {
+				showRemind(*service.bot, int64(job.UserID))
+			}
+		} else {
+			log.Printf("reminder of user %d is late, skipped: %v\n", job.UserID, job.DueAt)
+		}
+
+		jobLocation, err := loadTimezone(job.Timezone)
+		if err != nil {
+			jobLocation = location
+		}
+		dueAt, err := nextReminderTime(job.Reminder(), now.In(jobLocation))
+		if err != nil {
+			// Broken job is never due again
+			log.Printf("err: %v\n", err)
+			dueAt = now.AddDate(100, 0, 0)
+		}
+		if err = service.jobs.Complete(job, service.owner, dueAt); err != nil {
+			log.Printf("err: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Jobs in memory, it follows the rules of functions in base
+type memoryReminderJobs struct {
+	mu          sync.Mutex
+	generations map[int]int64
+	jobs        map[string]ReminderJob
+}
+
+func newMemoryReminderJobs() *memoryReminderJobs {
+	return &memoryReminderJobs{generations: map[int]int64{}, jobs: map[string]ReminderJob{}}
+}
+
+func (store *memoryReminderJobs) lock() func() {
+
+	// Calls of concurrent Set are mixed more this way
+	time.Sleep(time.Duration(rand.Intn(50)) * time.Microsecond)
+	store.mu.Lock()
+
+	return store.mu.Unlock
+}
+
+func (store *memoryReminderJobs) NextGeneration(userId int) (int64, error) {
+	defer store.lock()()
+	store.generations[userId]++
+	return store.generations[userId], nil
+}
+
+func (store *memoryReminderJobs) Put(job ReminderJob) error {
+	defer store.lock()()
+
+	id := fmt.Sprintf("%d/%s", job.UserID, job.Key)
+	old, ok := store.jobs[id]
+	if ok && old.Generation > job.Generation {
+		return nil
+	}
+	if ok && old.Timezone == job.Timezone {
+		old.Generation = job.Generation
+		store.jobs[id] = old
+		return nil
+	}
+	store.jobs[id] = job
+
+	return nil
+}
+
+func (store *memoryReminderJobs) DeleteStale(userId int) error {
+	defer store.lock()()
+
+	for id, job := range store.jobs {
+		if job.UserID == userId && job.Generation < store.generations[userId] {
+			delete(store.jobs, id)
+		}
+	}
+
+	return nil
+}
+
+func (store *memoryReminderJobs) Claim(owner string, now time.Time) (ReminderJob, error) {
+	defer store.lock()()
+
+	for id, job := range store.jobs {
+		if !job.DueAt.After(now) && !job.LeaseUntil.After(now) {
+			job.LeaseOwner, job.LeaseUntil = owner, now.Add(reminderLease)
+			store.jobs[id] = job
+			return job, nil
+		}
+	}
+
+	return ReminderJob{}, mongo.ErrNoDocuments
+}
+
+func (store *memoryReminderJobs) Complete(job ReminderJob, owner string, dueAt time.Time) error {
+	defer store.lock()()
+
+	id := fmt.Sprintf("%d/%s", job.UserID, job.Key)
+	if stored, ok := store.jobs[id]; ok && stored.LeaseOwner == owner {
+		stored.DueAt, stored.LeaseOwner, stored.LeaseUntil = dueAt, "", time.Time{}
+		store.jobs[id] = stored
+	}
+
+	return nil
+}
+
+// Jobs of user by key
+func (store *memoryReminderJobs) userJobs(userId int) map[string]ReminderJob {
+	defer store.lock()()
+
+	jobs := map[string]ReminderJob{}
+	for _, job := range store.jobs {
+		if job.UserID == userId {
+			jobs[job.Key] = job
+		}
+	}
+
+	return jobs
+}
+
+func jobKeys(jobs map[string]ReminderJob) string {
+
+	keys := make([]string, 0, len(jobs))
+	for key := range jobs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, " ")
+}
+
+func reminderKeys(reminders []Reminder) string {
+
+	keys := make([]string, 0, len(reminders))
+	for _, reminder := range reminders {
+		keys = append(keys, reminder.Key())
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, " ")
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("no time zone %v: %v", name, err)
+	}
+
+	return loc
+}
+
+func mustParseReminder(t *testing.T, text string) Reminder {
+
+	t.Helper()
+	reminder, err := parseReminder(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reminder
+}
+
+func TestNextReminderTime(t *testing.T) {
+
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	tests := []struct {
+		name     string
+		reminder string
+		after    time.Time
+		want     time.Time
+	}{
+		{"later today", "20:00", time.Date(2021, 10, 6, 9, 0, 0, 0, newYork), time.Date(2021, 10, 6, 20, 0, 0, 0, newYork)},
+		{"passed today", "08:00", time.Date(2021, 10, 6, 9, 0, 0, 0, newYork), time.Date(2021, 10, 7, 8, 0, 0, 0, newYork)},
+		{"exactly now", "09:00", time.Date(2021, 10, 6, 9, 0, 0, 0, newYork), time.Date(2021, 10, 7, 9, 0, 0, 0, newYork)},
+		// 2021-10-06 is Wednesday
+		{"next weekday", "08:00 mon,fri", time.Date(2021, 10, 6, 9, 0, 0, 0, newYork), time.Date(2021, 10, 8, 8, 0, 0, 0, newYork)},
+		{"weekday today", "20:00 wed", time.Date(2021, 10, 6, 9, 0, 0, 0, newYork), time.Date(2021, 10, 6, 20, 0, 0, 0, newYork)},
+		{"weekday today passed", "08:00 wed", time.Date(2021, 10, 6, 9, 0, 0, 0, newYork), time.Date(2021, 10, 13, 8, 0, 0, 0, newYork)},
+		{"weekends", "10:00 weekends", time.Date(2021, 10, 8, 11, 0, 0, 0, newYork), time.Date(2021, 10, 9, 10, 0, 0, 0, newYork)},
+		// Clocks go forward at 2:00 on 2021-03-14 and back at 2:00 on 2021-11-07
+		{"spring forward", "08:00", time.Date(2021, 3, 13, 9, 0, 0, 0, newYork), time.Date(2021, 3, 14, 8, 0, 0, 0, newYork)},
+		{"fall back", "08:00", time.Date(2021, 11, 6, 9, 0, 0, 0, newYork), time.Date(2021, 11, 7, 8, 0, 0, 0, newYork)},
+		{"skipped hour", "02:30", time.Date(2021, 3, 13, 9, 0, 0, 0, newYork), time.Date(2021, 3, 14, 3, 30, 0, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextReminderTime(mustParseReminder(t, tt.reminder), tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Wall clock is kept across the change, so the day is an hour shorter or longer
+	got, _ := nextReminderTime(mustParseReminder(t, "08:00"), time.Date(2021, 3, 13, 8, 30, 0, 0, newYork))
+	if d := got.Sub(time.Date(2021, 3, 13, 8, 0, 0, 0, newYork)); d != 23*time.Hour {
+		t.Errorf("day of spring forward is %v long", d)
+	}
+}
+
+func TestReminderServiceSet(t *testing.T) {
+
+	kyiv := mustLoadLocation(t, "Europe/Kiev")
+	newYork := mustLoadLocation(t, "America/New_York")
+	now := time.Date(2021, 10, 6, 12, 0, 0, 0, time.UTC)
+	store := newMemoryReminderJobs()
+	service := &ReminderService{owner: "test", jobs: store, now: func() time.Time { return now }}
+
+	evening := mustParseReminder(t, "20:00")
+	morning := mustParseReminder(t, "8:00 weekdays")
+	streak := Reminder{Time: streakReminderTime, Streak: true}
+
+	// Other user keeps own jobs whatever happens to the first one
+	other := []Reminder{evening, morning}
+	if err := service.Set(2, kyiv, other); err != nil {
+		t.Fatal(err)
+	}
+	otherJobs := store.userJobs(2)
+
+	check := func(step string, reminders []Reminder) map[string]ReminderJob {
+		t.Helper()
+		jobs := store.userJobs(1)
+		if jobKeys(jobs) != reminderKeys(reminders) {
+			t.Errorf("%v: jobs %q, want %q", step, jobKeys(jobs), reminderKeys(reminders))
+		}
+		if fmt.Sprint(store.userJobs(2)) != fmt.Sprint(otherJobs) {
+			t.Errorf("%v: jobs of other user are changed", step)
+		}
+		return jobs
+	}
+
+	// Add
+	reminders := []Reminder{evening, morning, streak}
+	if err := service.Set(1, kyiv, reminders); err != nil {
+		t.Fatal(err)
+	}
+	jobs := check("add", reminders)
+	if want := time.Date(2021, 10, 6, 20, 0, 0, 0, kyiv); !jobs[evening.Key()].DueAt.Equal(want) {
+		t.Errorf("add: evening reminder is due at %v, want %v", jobs[evening.Key()].DueAt, want)
+	}
+	if want := time.Date(2021, 10, 7, 8, 0, 0, 0, kyiv); !jobs[morning.Key()].DueAt.Equal(want) {
+		t.Errorf("add: morning reminder is due at %v, want %v", jobs[morning.Key()].DueAt, want)
+	}
+
+	// Unchanged reminders keep due time when time goes on, first reminder is removed without moving others
+	now = now.Add(3 * time.Hour)
+	reminders = []Reminder{morning, streak}
+	if err := service.Set(1, kyiv, reminders); err != nil {
+		t.Fatal(err)
+	}
+	updated := check("remove", reminders)
+	for _, reminder := range reminders {
+		if !updated[reminder.Key()].DueAt.Equal(jobs[reminder.Key()].DueAt) {
+			t.Errorf("remove: %v is moved from %v to %v", reminder, jobs[reminder.Key()].DueAt, updated[reminder.Key()].DueAt)
+		}
+	}
+
+	// New timezone moves due time
+	if err := service.Set(1, newYork, reminders); err != nil {
+		t.Fatal(err)
+	}
+	jobs = check("update", reminders)
+	if want := time.Date(2021, 10, 7, 8, 0, 0, 0, newYork); !jobs[morning.Key()].DueAt.Equal(want) || jobs[morning.Key()].Timezone != newYork.String() {
+		t.Errorf("update: morning reminder is due at %v %v, want %v", jobs[morning.Key()].DueAt, jobs[morning.Key()].Timezone, want)
+	}
+
+	// Remove all
+	if err := service.Set(1, newYork, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("remove all", nil)
+}
+
+func TestReminderServiceConcurrentSet(t *testing.T) {
+
+	kyiv := mustLoadLocation(t, "Europe/Kiev")
+	now := time.Date(2021, 10, 6, 12, 0, 0, 0, time.UTC)
+	store := newMemoryReminderJobs()
+	service := &ReminderService{owner: "test", jobs: store, now: func() time.Time { return now }}
+
+	lists := [][]Reminder{
+		nil,
+		{mustParseReminder(t, "20:00")},
+		{mustParseReminder(t, "20:00"), mustParseReminder(t, "8:00 weekdays")},
+		{mustParseReminder(t, "9:00 weekends"), {Time: streakReminderTime, Streak: true}},
+		{mustParseReminder(t, "7:00 mon"), mustParseReminder(t, "7:00 tue"), mustParseReminder(t, "7:00 wed")},
+	}
+
+	for round := 0; round < 200; round++ {
+		var wg sync.WaitGroup
+		for _, reminders := range lists {
+			wg.Add(1)
+			go func(reminders []Reminder) {
+				defer wg.Done()
+				if err := service.Set(1, kyiv, reminders); err != nil {
+					t.Error(err)
+				}
+			}(reminders)
+		}
+		wg.Wait()
+
+		// Jobs are of one list, not a mix of them
+		got := jobKeys(store.userJobs(1))
+		found := false
+		for _, reminders := range lists {
+			if got == reminderKeys(reminders) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("round %d: jobs %q aren't any of the lists", round, got)
+		}
+	}
+}
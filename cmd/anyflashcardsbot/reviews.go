@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Review is one answer of user, reviews are only appended and never changed
+type Review struct {
+	UserID       int                `bson:"userId"`
+	DictionaryID primitive.ObjectID `bson:"dictionaryId"`
+	FactID       primitive.ObjectID `bson:"factId"`
+	Reversed     bool               `bson:"reversed"`
+	Scheduler    string             `bson:"scheduler"`
+	// Chosen option or typed text, empty for blackout
+	Answer string `bson:"answer"`
+	// correctAnswer, nearAnswer, incorrectAnswer or blackout
+	Result  string `bson:"result"`
+	Correct bool   `bson:"correct"`
+	// Milliseconds from question to answer, see Stopwatch
+	ResponseTime int64 `bson:"responseTime"`
+	Quality      int   `bson:"quality"`
+	// Answers of Hot20, they don't change progress
+	Practice bool         `bson:"practice"`
+	Before   FactMetadata `bson:"before"`
+	After    FactMetadata `bson:"after"`
+	Date     time.Time    `bson:"date"`
+}
+
+func createReviewsIndex() error {
+
+	_, err := reviewsCollection.Indexes().CreateOne(
+		context.TODO(),
+		mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "userId", Value: 1},
+				{Key: "date", Value: 1},
+			},
+		},
+	)
+
+	return err
+}
+
+func dumpReviewToBase(review *Review) error {
+
+	_, err := reviewsCollection.InsertOne(context.TODO(), review)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
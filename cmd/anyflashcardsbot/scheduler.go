@@ -0,0 +1,366 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/burke/nanomemo/supermemo"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Scheduler decides when card is shown again. Every scheduler keeps own state in FactMetadata,
+// the state is stored with the rest of progress, Save and Load move it in and out on its own.
+type Scheduler interface {
+	// Due tells if card is up for review at the moment
+	Due(metadata *FactMetadata, now time.Time) bool
+	// Assess updates own state in metadata by quality of answer, from 0 (blackout) to 5 (perfect)
+	Assess(metadata *FactMetadata, quality int, now time.Time)
+	// Save serializes own state from metadata, other fields aren't touched
+	Save(metadata *FactMetadata) ([]byte, error)
+	// Load puts state serialized by Save back into metadata
+	Load(metadata *FactMetadata, state []byte) error
+}
+
+const (
+	sm2SchedulerName     = "sm2"
+	fsrsSchedulerName    = "fsrs"
+	leitnerSchedulerName = "leitner"
+)
+
+// Every answer is assessed by all schedulers, so switching between them doesn't lose history
+var schedulers = map[string]Scheduler{
+	sm2SchedulerName:     sm2Scheduler{},
+	fsrsSchedulerName:    newFSRSScheduler(),
+	leitnerSchedulerName: leitnerScheduler{intervals: []int{1, 2, 4, 8, 16}},
+}
+
+var schedulerNames = []string{sm2SchedulerName, fsrsSchedulerName, leitnerSchedulerName}
+
+var schedulerTitles = map[string]string{
+	sm2SchedulerName:     "SM-2",
+	fsrsSchedulerName:    "FSRS",
+	leitnerSchedulerName: "Leitner boxes",
+}
+
+var defaultSchedulerName = sm2SchedulerName
+
+// Unknown or empty name gives default scheduler
+func schedulerByName(name string) Scheduler {
+
+	if scheduler, ok := schedulers[name]; ok {
+		return scheduler
+	}
+
+	return schedulers[defaultSchedulerName]
+}
+
+// Layout of FactMetadata, version 0 has SM-2 fields only
+const factMetadataVersion = 1
+
+// Bring metadata of older version to the current one, state of new schedulers is guessed from SM-2 history
+func upgradeFactMetadata(metadata *FactMetadata) {
+
+	if metadata.Version >= factMetadataVersion {
+		return
+	}
+
+	if metadata.IntervalFrom != "" {
+
+		box := metadata.N + 1
+		if box > 5 {
+			box = 5
+		}
+		metadata.Leitner = LeitnerState{Box: box, Interval: metadata.Interval, LastReview: metadata.IntervalFrom}
+
+		// Interval is close to stability for 90% retention, low easiness means high difficulty
+		stability := float64(metadata.Interval)
+		if stability < 1 {
+			stability = 1
+		}
+		difficulty := 5 + (2.5-metadata.Ef)*4
+		metadata.FSRS = FSRSState{
+			Stability:  stability,
+			Difficulty: math.Min(math.Max(difficulty, 1), 10),
+			Interval:   metadata.Interval,
+			LastReview: metadata.IntervalFrom,
+		}
+	}
+
+	metadata.Version = factMetadataVersion
+}
+
+// Review dates are days in user timezone, so they are parsed in location of now
+func parseReviewDate(date string, location *time.Location) (time.Time, bool) {
+
+	t, err := time.ParseInLocation("2006-01-02", date, location)
+	if err != nil {
+		return t, false
+	}
+
+	return t, true
+}
+
+func reviewDate(now time.Time) string {
+	return now.Format("2006-01-02")
+}
+
+// Card is due after interval days from last review, never reviewed card is due at once
+func dueAfter(lastReview string, interval int, now time.Time) bool {
+
+	last, ok := parseReviewDate(lastReview, now.Location())
+	if !ok {
+		return true
+	}
+
+	return !now.Before(last.AddDate(0, 0, interval))
+}
+
+// SM-2 from nanomemo, its state is Ef, N, Interval and IntervalFrom
+type sm2Scheduler struct{}
+
+// SM-2 fields of FactMetadata, they are kept in FactMetadata itself for older progress
+type SM2State struct {
+	Ef           float64 `bson:"ef"`
+	Interval     int     `bson:"interval"`
+	IntervalFrom string  `bson:"intervalFrom"`
+	N            int     `bson:"n"`
+}
+
+func (sm2Scheduler) supermemoFact(metadata *FactMetadata) *supermemo.Fact {
+
+	// Fact was never reviewed, e.g. reverse card which was just turned on
+	if metadata.IntervalFrom == "" {
+		return supermemo.NewFact("", "")
+	}
+
+	smFact, err := supermemo.LoadFact("", "", metadata.Ef, metadata.N, metadata.Interval, metadata.IntervalFrom)
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return supermemo.NewFact("", "")
+	}
+
+	return smFact
+}
+
+// Same as UpForReview of nanomemo, but for any moment
+func (scheduler sm2Scheduler) Due(metadata *FactMetadata, now time.Time) bool {
+	return dueAfter(metadata.IntervalFrom, metadata.Interval, now)
+}
+
+func (scheduler sm2Scheduler) Assess(metadata *FactMetadata, quality int, now time.Time) {
+
+	smFact := scheduler.supermemoFact(metadata)
+	smFact.Assess(quality)
+
+	// nanomemo counts days of server, review belongs to the day of user
+	_, _, ef, n, interval, _ := smFact.Dump()
+	metadata.Ef = ef
+	metadata.N = n
+	metadata.Interval = interval
+	metadata.IntervalFrom = reviewDate(now)
+}
+
+func (scheduler sm2Scheduler) Save(metadata *FactMetadata) ([]byte, error) {
+	return bson.Marshal(SM2State{Ef: metadata.Ef, Interval: metadata.Interval, IntervalFrom: metadata.IntervalFrom, N: metadata.N})
+}
+
+func (scheduler sm2Scheduler) Load(metadata *FactMetadata, state []byte) error {
+
+	var sm2 SM2State
+	if err := bson.Unmarshal(state, &sm2); err != nil {
+		return err
+	}
+	metadata.Ef = sm2.Ef
+	metadata.Interval = sm2.Interval
+	metadata.IntervalFrom = sm2.IntervalFrom
+	metadata.N = sm2.N
+
+	return nil
+}
+
+// Leitner boxes, right answer (quality 3 and more) moves card to the next box,
+// wrong one moves it to the first box
+type leitnerScheduler struct {
+	// Days between reviews for every box
+	intervals []int
+}
+
+type LeitnerState struct {
+	Box        int    `bson:"box"`
+	Interval   int    `bson:"interval"`
+	LastReview string `bson:"lastReview"`
+}
+
+func (state LeitnerState) IsZero() bool {
+	return state == LeitnerState{}
+}
+
+func (scheduler leitnerScheduler) Due(metadata *FactMetadata, now time.Time) bool {
+
+	state := metadata.Leitner
+	if state.LastReview == "" {
+		return true
+	}
+
+	return dueAfter(state.LastReview, state.Interval, now)
+}
+
+func (scheduler leitnerScheduler) Assess(metadata *FactMetadata, quality int, now time.Time) {
+
+	state := &metadata.Leitner
+	state.LastReview = reviewDate(now)
+
+	// Wrong card is repeated today, like in SM-2
+	if quality < 3 {
+		state.Box = 1
+		state.Interval = 0
+		return
+	}
+
+	if state.Box == 0 {
+		state.Box = 1
+	} else if state.Box < len(scheduler.intervals) {
+		state.Box++
+	}
+	state.Interval = scheduler.intervals[state.Box-1]
+}
+
+func (scheduler leitnerScheduler) Save(metadata *FactMetadata) ([]byte, error) {
+	return bson.Marshal(metadata.Leitner)
+}
+
+func (scheduler leitnerScheduler) Load(metadata *FactMetadata, state []byte) error {
+
+	var leitner LeitnerState
+	if err := bson.Unmarshal(state, &leitner); err != nil {
+		return err
+	}
+	metadata.Leitner = leitner
+
+	return nil
+}
+
+// FSRS v4.5 with default weights, intervals are counted in days for 90% retention
+type fsrsScheduler struct {
+	weights   [17]float64
+	retention float64
+}
+
+func newFSRSScheduler() fsrsScheduler {
+	return fsrsScheduler{
+		weights:   [17]float64{0.4872, 1.4003, 3.7145, 13.8206, 5.1618, 1.2298, 0.8975, 0.031, 1.6474, 0.1367, 1.0461, 2.1072, 0.0793, 0.3246, 1.587, 0.2272, 2.8755},
+		retention: 0.9,
+	}
+}
+
+type FSRSState struct {
+	Stability  float64 `bson:"stability"`
+	Difficulty float64 `bson:"difficulty"`
+	Interval   int     `bson:"interval"`
+	LastReview string  `bson:"lastReview"`
+}
+
+func (state FSRSState) IsZero() bool {
+	return state == FSRSState{}
+}
+
+const (
+	fsrsDecay  = -0.5
+	fsrsFactor = 19.0 / 81.0
+)
+
+// FSRS grades are 1 (again), 2 (hard), 3 (good) and 4 (easy)
+func fsrsGrade(quality int) float64 {
+
+	switch {
+	case quality < 3:
+		return 1
+	case quality == 3:
+		return 2
+	case quality == 4:
+		return 3
+	}
+
+	return 4
+}
+
+func (scheduler fsrsScheduler) retrievability(elapsedDays float64, stability float64) float64 {
+	return math.Pow(1+fsrsFactor*elapsedDays/stability, fsrsDecay)
+}
+
+func (scheduler fsrsScheduler) initialDifficulty(grade float64) float64 {
+	return scheduler.weights[4] - (grade-3)*scheduler.weights[5]
+}
+
+func (scheduler fsrsScheduler) Due(metadata *FactMetadata, now time.Time) bool {
+
+	state := metadata.FSRS
+	if state.LastReview == "" {
+		return true
+	}
+
+	return dueAfter(state.LastReview, state.Interval, now)
+}
+
+func (scheduler fsrsScheduler) Assess(metadata *FactMetadata, quality int, now time.Time) {
+
+	w := scheduler.weights
+	state := &metadata.FSRS
+	grade := fsrsGrade(quality)
+
+	if state.LastReview == "" {
+		state.Stability = w[int(grade)-1]
+		state.Difficulty = scheduler.initialDifficulty(grade)
+	} else {
+		elapsedDays := 0.0
+		if last, ok := parseReviewDate(state.LastReview, now.Location()); ok {
+			elapsedDays = math.Max(math.Floor(now.Sub(last).Hours()/24), 0)
+		}
+		r := scheduler.retrievability(elapsedDays, state.Stability)
+		d := state.Difficulty
+
+		if grade == 1 {
+			state.Stability = w[11] * math.Pow(d, -w[12]) * (math.Pow(state.Stability+1, w[13]) - 1) * math.Exp(w[14]*(1-r))
+		} else {
+			bonus := 1.0
+			if grade == 2 {
+				bonus = w[15]
+			} else if grade == 4 {
+				bonus = w[16]
+			}
+			state.Stability = state.Stability * (1 + math.Exp(w[8])*(11-d)*math.Pow(state.Stability, -w[9])*(math.Exp(w[10]*(1-r))-1)*bonus)
+		}
+
+		// Difficulty moves by grade and reverts to difficulty of good answer
+		d = d - w[6]*(grade-3)
+		state.Difficulty = w[7]*scheduler.initialDifficulty(3) + (1-w[7])*d
+	}
+	state.Difficulty = math.Min(math.Max(state.Difficulty, 1), 10)
+	state.Stability = math.Max(state.Stability, 0.1)
+
+	// Wrong card is repeated today, like in SM-2
+	state.LastReview = reviewDate(now)
+	if grade == 1 {
+		state.Interval = 0
+		return
+	}
+
+	interval := state.Stability / fsrsFactor * (math.Pow(scheduler.retention, 1/fsrsDecay) - 1)
+	state.Interval = int(math.Max(math.Round(interval), 1))
+}
+
+func (scheduler fsrsScheduler) Save(metadata *FactMetadata) ([]byte, error) {
+	return bson.Marshal(metadata.FSRS)
+}
+
+func (scheduler fsrsScheduler) Load(metadata *FactMetadata, state []byte) error {
+
+	var fsrs FSRSState
+	if err := bson.Unmarshal(state, &fsrs); err != nil {
+		return err
+	}
+	metadata.FSRS = fsrs
+
+	return nil
+}
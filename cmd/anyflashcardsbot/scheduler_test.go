@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSchedulerSaveLoad(t *testing.T) {
+
+	now := time.Date(2021, 10, 5, 12, 0, 0, 0, time.UTC)
+	metadata := FactMetadata{}
+	for i := 0; i < 3; i++ {
+		for _, name := range schedulerNames {
+			schedulers[name].Assess(&metadata, 4, now.AddDate(0, 0, i))
+		}
+	}
+
+	for _, name := range schedulerNames {
+		t.Run(name, func(t *testing.T) {
+
+			state, err := schedulers[name].Save(&metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored := FactMetadata{}
+			if err = schedulers[name].Load(&restored, state); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := schedulers[name].Save(&restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saved, state) {
+				t.Errorf("state isn't restored: got %v, want %v", bson.Raw(saved), bson.Raw(state))
+			}
+			if schedulers[name].Due(&restored, now.AddDate(0, 0, 2)) != schedulers[name].Due(&metadata, now.AddDate(0, 0, 2)) {
+				t.Errorf("restored card is due at other time")
+			}
+
+			// Load doesn't touch state of other schedulers
+			untouched := FactMetadata{N: 7, Leitner: LeitnerState{Box: 5}, FSRS: FSRSState{Stability: 3}}
+			before := untouched
+			if err = schedulers[name].Load(&untouched, state); err != nil {
+				t.Fatal(err)
+			}
+			switch name {
+			case sm2SchedulerName:
+				untouched.Ef, untouched.N, untouched.Interval, untouched.IntervalFrom = before.Ef, before.N, before.Interval, before.IntervalFrom
+			case fsrsSchedulerName:
+				untouched.FSRS = before.FSRS
+			case leitnerSchedulerName:
+				untouched.Leitner = before.Leitner
+			}
+			if untouched != before {
+				t.Errorf("state of other schedulers is changed: got %+v, want %+v", untouched, before)
+			}
+		})
+	}
+}
+
+func TestLeitnerBoxes(t *testing.T) {
+
+	scheduler := leitnerScheduler{intervals: []int{1, 2, 4, 8, 16}}
+	now := time.Date(2021, 10, 5, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		box     int
+		quality int
+		wantBox int
+	}{
+		{0, 3, 1},
+		{1, 3, 2},
+		{2, 5, 3},
+		{5, 4, 5},
+		{4, 2, 1},
+		{3, 0, 1},
+	}
+
+	for _, tt := range tests {
+		metadata := FactMetadata{Leitner: LeitnerState{Box: tt.box, Interval: 1, LastReview: "2021-10-01"}}
+		scheduler.Assess(&metadata, tt.quality, now)
+		if metadata.Leitner.Box != tt.wantBox {
+			t.Errorf("box %d, quality %d: got box %d, want %d", tt.box, tt.quality, metadata.Leitner.Box, tt.wantBox)
+		}
+	}
+}
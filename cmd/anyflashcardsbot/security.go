@@ -15,6 +15,12 @@ var statuses = map[string]string{
 	"restricted":    "invalid",
 }
 
+// Creator and admins of native group manage other users, e.g. set their quotas
+func isAdmin(userId int) bool {
+	status := sessions.Get(userId).Membership
+	return status == "administrator" || status == "creator"
+}
+
 func checkMembership(bot *tgbotapi.BotAPI, update *tgbotapi.Update) bool {
 
 	// Get initiator from any update
@@ -50,15 +56,14 @@ func checkMembership(bot *tgbotapi.BotAPI, update *tgbotapi.Update) bool {
 	}
 
 	// Check membership in native group
-	status := membership[user.ID]
-	var err error
+	status := sessions.Get(user.ID).Membership
 	if statuses[status] == "" {
 
 		if err := addNewUser(bot, user); err != nil {
 			log.Panic(err)
 		}
 
-		if membership, err = loadAllUsersStatusFromBase(); err != nil {
+		if err := reloadMembership(); err != nil {
 			log.Panic(err)
 		}
 
@@ -66,7 +71,7 @@ func checkMembership(bot *tgbotapi.BotAPI, update *tgbotapi.Update) bool {
 
 	} else if statuses[status] != "valid" {
 
-		if membership, err = loadAllUsersStatusFromBase(); err != nil {
+		if err := reloadMembership(); err != nil {
 			log.Panic(err)
 		}
 
@@ -75,3 +80,20 @@ func checkMembership(bot *tgbotapi.BotAPI, update *tgbotapi.Update) bool {
 
 	return true
 }
+
+func reloadMembership() error {
+
+	membership, err := loadAllUsersStatusFromBase()
+	if err != nil {
+		return err
+	}
+
+	for userId, status := range membership {
+		status := status
+		sessions.Update(userId, func(session *Session) {
+			session.Membership = status
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Stopwatch struct {
+	start time.Time
+	mark  time.Duration
+}
+
+// Everything bot keeps about one user, see SessionStore
+type Session struct {
+	// Facts of current quiz and index of next question
+	ForReview FactSet
+	Index     int
+	// ID of current quiz round and keyboard position of correct answer
+	QuizID        string
+	CorrectOption int
+	// Answers on keyboard of current question and answer given by user, for review log
+	Options []string
+	Answer  string
+	// All cards of dictionary, source of wrong answers
+	Cards FactSet
+	// Hot20 sessions are practice only, results aren't dumped into base
+	Practice bool
+	// Answers are typed instead of chosen from keyboard
+	Typing        bool
+	AnswerOptions int
+	Stopwatch     Stopwatch
+	Quality       int
+	// Answers given during quiz, for summary
+	Right int
+	Wrong int
+
+	// Status in native group, see statuses in security.go
+	Membership string
+	// Timezone of user, nil until it is loaded, see userLocation
+	Location *time.Location
+
+	Conversation Conversation
+}
+
+// Copy with own slices of quiz, so facts of the copy can't be changed outside of store.
+// Cards are shared, they are only replaced and never changed in place
+func (session Session) copy() Session {
+
+	session.ForReview = append(FactSet(nil), session.ForReview...)
+	session.Options = append([]string(nil), session.Options...)
+
+	return session
+}
+
+// SessionStore keeps sessions of all users, it is safe for concurrent use
+type SessionStore interface {
+	// Get returns copy of user session, empty session if user is unknown.
+	// Changes of the copy don't get into store, use Update for them
+	Get(userId int) Session
+	// Update changes user session in place, creates session if it doesn't exist
+	Update(userId int, update func(session *Session))
+	Delete(userId int)
+	// Range calls f for snapshot of every session until f returns false
+	Range(f func(userId int, session Session) bool)
+}
+
+type memorySessionStore struct {
+	mutex    sync.RWMutex
+	sessions map[int]*Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[int]*Session{}}
+}
+
+func (store *memorySessionStore) Get(userId int) Session {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if session, ok := store.sessions[userId]; ok {
+		return session.copy()
+	}
+
+	return Session{}
+}
+
+func (store *memorySessionStore) Update(userId int, update func(session *Session)) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session, ok := store.sessions[userId]
+	if !ok {
+		session = &Session{}
+		store.sessions[userId] = session
+	}
+
+	update(session)
+}
+
+func (store *memorySessionStore) Delete(userId int) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.sessions, userId)
+}
+
+func (store *memorySessionStore) Range(f func(userId int, session Session) bool) {
+
+	// Copy sessions for calling f without lock, so f can update store
+	store.mutex.RLock()
+	snapshot := make(map[int]Session, len(store.sessions))
+	for userId, session := range store.sessions {
+		snapshot[userId] = session.copy()
+	}
+	store.mutex.RUnlock()
+
+	for userId, session := range snapshot {
+		if !f(userId, session) {
+			return
+		}
+	}
+}
+
+// Sessions in base are shared by replicas of bot, see webhookURL.
+// Update of session which is changed by other replica meanwhile is repeated
+type mongoSessionStore struct{}
+
+var maxSessionUpdateAttempts = 10
+
+func newMongoSessionStore() *mongoSessionStore {
+	return &mongoSessionStore{}
+}
+
+func (store *mongoSessionStore) Get(userId int) Session {
+
+	stored, err := loadSessionFromBase(userId)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("err: %v\n", err)
+	}
+
+	return sessionFromStored(stored)
+}
+
+func (store *mongoSessionStore) Update(userId int, update func(session *Session)) {
+
+	for attempt := 0; attempt < maxSessionUpdateAttempts; attempt++ {
+		stored, err := loadSessionFromBase(userId)
+		if err != nil && err != mongo.ErrNoDocuments {
+			log.Printf("err: %v\n", err)
+			return
+		}
+
+		session := sessionFromStored(stored)
+		cards := session.Cards
+		update(&session)
+
+		// Cards are only replaced, so the same slice means they aren't changed
+		withCards := len(cards) != len(session.Cards) || len(cards) != 0 && &cards[0] != &session.Cards[0]
+		ok, err := dumpSessionToBase(storedFromSession(userId, session), stored.Version, withCards)
+		if err != nil {
+			log.Printf("err: %v\n", err)
+			return
+		}
+		if ok {
+			return
+		}
+	}
+
+	log.Printf("err: session of user %d isn't updated, it is changed by others all the time\n", userId)
+}
+
+func (store *mongoSessionStore) Delete(userId int) {
+
+	if err := deleteSessionFromBase(userId); err != nil {
+		log.Printf("err: %v\n", err)
+	}
+}
+
+func (store *mongoSessionStore) Range(f func(userId int, session Session) bool) {
+
+	stored, err := loadAllSessionsFromBase()
+	if err != nil {
+		log.Printf("err: %v\n", err)
+		return
+	}
+
+	for _, session := range stored {
+		if !f(session.UserID, sessionFromStored(session)) {
+			return
+		}
+	}
+}
+
+func sessionFromStored(stored StoredSession) Session {
+
+	session := Session{
+		ForReview:     stored.ForReview,
+		Index:         stored.Index,
+		QuizID:        stored.QuizID,
+		CorrectOption: stored.CorrectOption,
+		Options:       stored.Options,
+		Answer:        stored.Answer,
+		Cards:         stored.Cards,
+		Practice:      stored.Practice,
+		Typing:        stored.Typing,
+		AnswerOptions: stored.AnswerOptions,
+		Stopwatch:     Stopwatch{start: stored.StopwatchStart, mark: stored.StopwatchMark},
+		Quality:       stored.Quality,
+		Right:         stored.Right,
+		Wrong:         stored.Wrong,
+		Membership:    stored.Membership,
+		Conversation:  stored.Conversation,
+	}
+
+	// Unknown zone is loaded again, see userLocation
+	if stored.Timezone != "" {
+		if userLocation, err := loadTimezone(stored.Timezone); err == nil {
+			session.Location = userLocation
+		}
+	}
+
+	return session
+}
+
+func storedFromSession(userId int, session Session) StoredSession {
+
+	stored := StoredSession{
+		UserID:         userId,
+		ForReview:      session.ForReview,
+		Index:          session.Index,
+		QuizID:         session.QuizID,
+		CorrectOption:  session.CorrectOption,
+		Options:        session.Options,
+		Answer:         session.Answer,
+		Cards:          session.Cards,
+		Practice:       session.Practice,
+		Typing:         session.Typing,
+		AnswerOptions:  session.AnswerOptions,
+		StopwatchStart: session.Stopwatch.start,
+		StopwatchMark:  session.Stopwatch.mark,
+		Quality:        session.Quality,
+		Right:          session.Right,
+		Wrong:          session.Wrong,
+		Membership:     session.Membership,
+		Conversation:   session.Conversation,
+	}
+	if session.Location != nil {
+		stored.Timezone = session.Location.String()
+	}
+
+	return stored
+}
+
+// Memory is enough for one replica, see main
+var sessions SessionStore = newMemorySessionStore()
+
+// Dump quiz progress of user, so it can be restored after restart
+func saveQuizSession(userId int) {
+
+	session := sessions.Get(userId)
+	quizSession := QuizSession{
+		UserID:         userId,
+		FactSet:        session.ForReview,
+		Index:          session.Index,
+		QuizID:         session.QuizID,
+		CorrectOption:  session.CorrectOption,
+		Practice:       session.Practice,
+		Typing:         session.Typing,
+		AnswerOptions:  session.AnswerOptions,
+		StopwatchStart: session.Stopwatch.start,
+		Right:          session.Right,
+		Wrong:          session.Wrong,
+		Date:           time.Now(),
+	}
+
+	if err := dumpQuizSessionToBase(&quizSession); err != nil {
+		log.Printf("err: %v\n", err)
+	}
+}
+
+func forgetQuizSession(userId int) {
+
+	if err := deleteQuizSessionFromBase(userId); err != nil {
+		log.Printf("err: %v\n", err)
+	}
+}
+
+func restoreQuizSessions() error {
+
+	quizSessions, err := loadAllQuizSessionsFromBase()
+	if err != nil {
+		return err
+	}
+
+	for _, quizSession := range quizSessions {
+		quizSession := quizSession
+		sessions.Update(quizSession.UserID, func(session *Session) {
+			session.ForReview = quizSession.FactSet
+			session.Index = quizSession.Index
+			session.QuizID = quizSession.QuizID
+			session.CorrectOption = quizSession.CorrectOption
+			session.Practice = quizSession.Practice
+			session.Typing = quizSession.Typing
+			session.AnswerOptions = quizSession.AnswerOptions
+			session.Stopwatch = Stopwatch{start: quizSession.StopwatchStart}
+			session.Right = quizSession.Right
+			session.Wrong = quizSession.Wrong
+		})
+		setState(quizSession.UserID, InQuiz)
+	}
+
+	log.Printf("Restored quiz sessions: %v\n", len(quizSessions))
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoredSession(t *testing.T) {
+
+	kyiv := mustLoadLocation(t, "Europe/Kiev")
+	session := Session{
+		ForReview:     FactSet{{Question: "cat", Answer: "кіт"}},
+		Index:         1,
+		QuizID:        "quiz",
+		CorrectOption: 2,
+		Options:       []string{"кіт", "пес"},
+		Cards:         FactSet{{Question: "cat", Answer: "кіт"}, {Question: "dog", Answer: "пес"}},
+		Typing:        true,
+		AnswerOptions: 4,
+		Stopwatch:     Stopwatch{start: time.Date(2021, 10, 6, 12, 0, 0, 0, time.UTC), mark: 3 * time.Second},
+		Quality:       5,
+		Right:         1,
+		Membership:    "member",
+		Location:      kyiv,
+		Conversation:  Conversation{State: AwaitingTimezone, Since: time.Date(2021, 10, 6, 12, 0, 0, 0, time.UTC)},
+	}
+
+	stored := storedFromSession(7, session)
+	if stored.UserID != 7 || stored.Timezone != "Europe/Kiev" {
+		t.Errorf("stored session is of user %d in %q", stored.UserID, stored.Timezone)
+	}
+
+	restored := sessionFromStored(stored)
+	if restored.Location.String() != kyiv.String() {
+		t.Errorf("location is %v, want %v", restored.Location, kyiv)
+	}
+	restored.Location = kyiv
+	if !reflect.DeepEqual(restored, session) {
+		t.Errorf("got %+v, want %+v", restored, session)
+	}
+
+	// Location isn't loaded yet
+	if sessionFromStored(StoredSession{}).Location != nil {
+		t.Errorf("empty session has location")
+	}
+}
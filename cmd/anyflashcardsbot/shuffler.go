@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Buttons in each row of quiz keyboard for every available number of options
+var answerLayouts = map[int][]int{
+	2: {2},
+	4: {2, 2},
+	6: {2, 2, 2},
+}
+
+var defaultAnswerOptions = 4
+
+// AnswerShuffler places correct answer among distractors, it is safe for concurrent use
+type AnswerShuffler struct {
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// Same seed gives same placements, which is useful for reproducing a quiz
+func newAnswerShuffler(seed int64) *AnswerShuffler {
+	return &AnswerShuffler{random: rand.New(rand.NewSource(seed))}
+}
+
+var answerShuffler = newAnswerShuffler(time.Now().UnixNano())
+
+// Shuffle returns options in random order and position of correct answer,
+// every position has the same chance to hold correct answer
+func (shuffler *AnswerShuffler) Shuffle(correct string, distractors []string) (options []string, correctPosition int) {
+	shuffler.mutex.Lock()
+	defer shuffler.mutex.Unlock()
+
+	all := append([]string{correct}, distractors...)
+	options = make([]string, len(all))
+
+	// Correct answer is the first one in all
+	for i, j := range shuffler.random.Perm(len(all)) {
+		options[i] = all[j]
+		if j == 0 {
+			correctPosition = i
+		}
+	}
+
+	return options, correctPosition
+}
+
+// Layout splits options into keyboard rows, unknown number of options gets rows by two
+func answerLayout(options int) []int {
+
+	if layout, ok := answerLayouts[options]; ok {
+		return layout
+	}
+
+	var layout []int
+	for ; options > 0; options -= 2 {
+		if options == 1 {
+			layout = append(layout, 1)
+		} else {
+			layout = append(layout, 2)
+		}
+	}
+
+	return layout
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestAnswerShufflerUniform(t *testing.T) {
+
+	const draws = 60000
+
+	for _, options := range []int{2, 4, 6} {
+		t.Run(fmt.Sprintf("%d options", options), func(t *testing.T) {
+
+			shuffler := newAnswerShuffler(42)
+			distractors := make([]string, options-1)
+			for i := range distractors {
+				distractors[i] = fmt.Sprintf("wrong%d", i)
+			}
+
+			histogram := make([]int, options)
+			for i := 0; i < draws; i++ {
+				shuffled, position := shuffler.Shuffle("right", distractors)
+				if len(shuffled) != options || shuffled[position] != "right" {
+					t.Fatalf("correct answer isn't at position %d of %v", position, shuffled)
+				}
+				histogram[position]++
+			}
+
+			// Chi-squared test, limits are for p = 0.001 with options-1 degrees of freedom
+			limits := map[int]float64{2: 10.83, 4: 16.27, 6: 20.52}
+			expected := float64(draws) / float64(options)
+			chiSquared := 0.0
+			for _, count := range histogram {
+				chiSquared += (float64(count) - expected) * (float64(count) - expected) / expected
+			}
+			if chiSquared > limits[options] {
+				t.Errorf("placement isn't uniform, histogram %v, chi-squared %.2f", histogram, chiSquared)
+			}
+		})
+	}
+}
+
+func TestAnswerShufflerSeed(t *testing.T) {
+
+	distractors := []string{"b", "c", "d"}
+	first, second := newAnswerShuffler(7), newAnswerShuffler(7)
+
+	for i := 0; i < 100; i++ {
+		options1, position1 := first.Shuffle("a", distractors)
+		options2, position2 := second.Shuffle("a", distractors)
+		if fmt.Sprint(options1) != fmt.Sprint(options2) || position1 != position2 {
+			t.Fatalf("same seed gives %v and %v", options1, options2)
+		}
+
+		sorted := append([]string(nil), options1...)
+		sort.Strings(sorted)
+		if fmt.Sprint(sorted) != "[a b c d]" {
+			t.Fatalf("options are lost: %v", options1)
+		}
+	}
+}
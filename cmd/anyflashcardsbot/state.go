@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// State of conversation with each user, decides how to treat next message
+type ConversationState int
+
+const (
+	Idle ConversationState = iota
+	AwaitingDictionary
+	AwaitingReminderTime
+	InQuiz
+	AwaitingDictionaryName
+	AwaitingTimezone
+)
+
+// After timeout without any activity conversation falls back to Idle
+var stateTimeouts = map[ConversationState]time.Duration{
+	AwaitingDictionary:     10 * time.Minute,
+	AwaitingReminderTime:   5 * time.Minute,
+	InQuiz:                 time.Hour,
+	AwaitingDictionaryName: 5 * time.Minute,
+	AwaitingTimezone:       5 * time.Minute,
+}
+
+type Conversation struct {
+	State ConversationState
+	Since time.Time
+	// Dictionary user is renaming
+	DictionaryID primitive.ObjectID
+}
+
+func getState(userId int) (state ConversationState) {
+
+	sessions.Update(userId, func(session *Session) {
+		conversation := session.Conversation
+		if timeout, ok := stateTimeouts[conversation.State]; ok && time.Since(conversation.Since) > timeout {
+			session.Conversation = Conversation{}
+		}
+		state = session.Conversation.State
+	})
+
+	return state
+}
+
+func setState(userId int, state ConversationState) {
+
+	sessions.Update(userId, func(session *Session) {
+		session.Conversation = Conversation{State: state, Since: time.Now()}
+	})
+}
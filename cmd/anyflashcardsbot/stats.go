@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Width of the longest bar in text charts
+var statsBarWidth = 12
+
+var statsDays = 30
+
+type Stats struct {
+	Cards   int
+	New     int
+	Learned int
+	DueNow  int
+	// Due during next seven days, not counting cards due now
+	DueWeek int
+	// Answers of last statsDays days, practice isn't counted
+	Answers      int
+	Correct      int
+	ResponseTime time.Duration
+	// Days in a row with at least one answer, today isn't required yet
+	Streak int
+	// Answers of every day of the last week, the last one is today
+	Week [7]int
+}
+
+func loadReviewsFromBase(userId int, since time.Time) (reviews []Review, err error) {
+
+	cursor, err := reviewsCollection.Find(context.TODO(), bson.M{
+		"userId":   userId,
+		"practice": false,
+		"date":     bson.M{"$gte": since},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cursor.All(context.TODO(), &reviews); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+func collectStats(userId int, now time.Time) (stats Stats, err error) {
+
+	dictionaries, err := loadActiveDictionariesFromBase(userId)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, dictionary := range dictionaries {
+		for _, card := range dictionary.Cards() {
+			scheduler := schedulerByName(card.Scheduler)
+			stats.Cards++
+
+			if card.IntervalFrom == "" {
+				stats.New++
+				continue
+			}
+			if card.N > 0 {
+				stats.Learned++
+			}
+			if scheduler.Due(&card.FactMetadata, now) {
+				stats.DueNow++
+			} else if scheduler.Due(&card.FactMetadata, now.AddDate(0, 0, 7)) {
+				stats.DueWeek++
+			}
+		}
+	}
+
+	reviews, err := loadReviewsFromBase(userId, now.AddDate(0, 0, -statsDays))
+	if err != nil {
+		return stats, err
+	}
+
+	location := userLocation(userId)
+	now = now.In(location)
+	days := map[string]int{}
+	var responseTime int64
+	for _, review := range reviews {
+		stats.Answers++
+		if review.Correct {
+			stats.Correct++
+		}
+		responseTime += review.ResponseTime
+		days[review.Date.In(location).Format("2006-01-02")]++
+	}
+	if stats.Answers > 0 {
+		stats.ResponseTime = time.Duration(responseTime/int64(stats.Answers)) * time.Millisecond
+	}
+
+	for i := range stats.Week {
+		day := now.AddDate(0, 0, i-len(stats.Week)+1).Format("2006-01-02")
+		stats.Week[i] = days[day]
+	}
+
+	stats.Streak = streakDays(days, now)
+
+	return stats, nil
+}
+
+// Days in a row with answers, days are like 2006-01-02 in location of now.
+// Streak isn't broken until today is over
+func streakDays(days map[string]int, now time.Time) int {
+
+	streak := 0
+	day := now
+	if days[now.Format("2006-01-02")] == 0 {
+		day = day.AddDate(0, 0, -1)
+	}
+	for days[day.Format("2006-01-02")] > 0 {
+		streak++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	return streak
+}
+
+func textBar(value, max int) string {
+
+	if max == 0 || value == 0 {
+		return ""
+	}
+
+	width := value * statsBarWidth / max
+	if width == 0 {
+		width = 1
+	}
+
+	return strings.Repeat("█", width)
+}
+
+// Now is in user timezone
+func formatStats(stats Stats, now time.Time) string {
+
+	var text strings.Builder
+
+	fmt.Fprintf(&text, "Cards: %d\n", stats.Cards)
+	fmt.Fprintf(&text, "Learned: %d %s\n", stats.Learned, textBar(stats.Learned, stats.Cards))
+	fmt.Fprintf(&text, "New: %d %s\n", stats.New, textBar(stats.New, stats.Cards))
+	fmt.Fprintf(&text, "Due today: %d\n", stats.DueNow)
+	fmt.Fprintf(&text, "Due this week: %d\n\n", stats.DueWeek)
+
+	fmt.Fprintf(&text, "Last %d days:\n", statsDays)
+	if stats.Answers > 0 {
+		retention := stats.Correct * 100 / stats.Answers
+		fmt.Fprintf(&text, "Retention: %d%% %s\n", retention, textBar(retention, 100))
+		fmt.Fprintf(&text, "Average answer time: %.1fs\n", stats.ResponseTime.Seconds())
+	} else {
+		text.WriteString("No answers yet\n")
+	}
+	fmt.Fprintf(&text, "Streak: %d days\n\n", stats.Streak)
+
+	max := 0
+	for _, answers := range stats.Week {
+		if answers > max {
+			max = answers
+		}
+	}
+	text.WriteString("Answers this week:\n")
+	for i, answers := range stats.Week {
+		day := now.AddDate(0, 0, i-len(stats.Week)+1)
+		fmt.Fprintf(&text, "%s %s %d\n", day.Format("Mon"), textBar(answers, max), answers)
+	}
+
+	return text.String()
+}
+
+func showStats(bot *tgbotapi.BotAPI, userId int) error {
+
+	now := userNow(userId)
+	stats, err := collectStats(userId, now)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(int64(userId), formatStats(stats, now))
+	msg.ReplyMarkup = statsKeyboard
+	if _, err = bot.Send(msg); err != nil {
+		return err
+	}
+
+	return nil
+}
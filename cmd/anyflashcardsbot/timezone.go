@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	// Zone database is built in, so zones are found without system files
+	_ "time/tzdata"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Zones offered as buttons, any other IANA name can be typed
+var commonTimezones = []string{
+	"Europe/Kiev", "Europe/Warsaw", "Europe/Berlin", "Europe/London",
+	"America/New_York", "America/Chicago", "America/Los_Angeles", "Asia/Tbilisi",
+}
+
+// Big cities for finding zone by location without external services
+var timezoneCities = []struct {
+	timezone  string
+	latitude  float64
+	longitude float64
+}{
+	{"Europe/Kiev", 50.45, 30.52},
+	{"Europe/Kiev", 46.48, 30.73},
+	{"Europe/Kiev", 49.84, 24.03},
+	{"Europe/Kiev", 49.99, 36.23},
+	{"Europe/Warsaw", 52.23, 21.01},
+	{"Europe/Berlin", 52.52, 13.40},
+	{"Europe/Berlin", 48.14, 11.58},
+	{"Europe/Prague", 50.08, 14.44},
+	{"Europe/Vienna", 48.21, 16.37},
+	{"Europe/Budapest", 47.50, 19.04},
+	{"Europe/Bucharest", 44.43, 26.10},
+	{"Europe/Chisinau", 47.01, 28.86},
+	{"Europe/Minsk", 53.90, 27.56},
+	{"Europe/Vilnius", 54.69, 25.28},
+	{"Europe/Riga", 56.95, 24.11},
+	{"Europe/Tallinn", 59.44, 24.75},
+	{"Europe/Helsinki", 60.17, 24.94},
+	{"Europe/Stockholm", 59.33, 18.07},
+	{"Europe/Oslo", 59.91, 10.75},
+	{"Europe/Copenhagen", 55.68, 12.57},
+	{"Europe/Amsterdam", 52.37, 4.90},
+	{"Europe/Brussels", 50.85, 4.35},
+	{"Europe/Paris", 48.86, 2.35},
+	{"Europe/London", 51.51, -0.13},
+	{"Europe/Dublin", 53.35, -6.26},
+	{"Europe/Lisbon", 38.72, -9.14},
+	{"Europe/Madrid", 40.42, -3.70},
+	{"Europe/Rome", 41.90, 12.50},
+	{"Europe/Athens", 37.98, 23.73},
+	{"Europe/Istanbul", 41.01, 28.98},
+	{"Europe/Moscow", 55.76, 37.62},
+	{"Asia/Tbilisi", 41.72, 44.79},
+	{"Asia/Yerevan", 40.18, 44.51},
+	{"Asia/Baku", 40.41, 49.87},
+	{"Asia/Jerusalem", 31.77, 35.21},
+	{"Asia/Dubai", 25.20, 55.27},
+	{"Asia/Almaty", 43.24, 76.89},
+	{"Asia/Tashkent", 41.30, 69.24},
+	{"Asia/Kolkata", 28.61, 77.21},
+	{"Asia/Bangkok", 13.76, 100.50},
+	{"Asia/Singapore", 1.35, 103.82},
+	{"Asia/Shanghai", 31.23, 121.47},
+	{"Asia/Tokyo", 35.68, 139.69},
+	{"Asia/Seoul", 37.57, 126.98},
+	{"Australia/Sydney", -33.87, 151.21},
+	{"Africa/Cairo", 30.04, 31.24},
+	{"Africa/Lagos", 6.52, 3.38},
+	{"Africa/Johannesburg", -26.20, 28.05},
+	{"America/Sao_Paulo", -23.55, -46.63},
+	{"America/Buenos_Aires", -34.60, -58.38},
+	{"America/Mexico_City", 19.43, -99.13},
+	{"America/Bogota", 4.71, -74.07},
+	{"America/New_York", 40.71, -74.01},
+	{"America/Toronto", 43.65, -79.38},
+	{"America/Chicago", 41.88, -87.63},
+	{"America/Denver", 39.74, -104.99},
+	{"America/Los_Angeles", 34.05, -118.24},
+	{"America/Vancouver", 49.28, -123.12},
+}
+
+// Farther than that from any city, zone is guessed by longitude
+var maxCityDistance = 500.0
+
+// Great circle distance in kilometers
+func distance(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+
+	const earthRadius = 6371.0
+	toRadians := math.Pi / 180
+
+	dLatitude := (latitude2 - latitude1) * toRadians
+	dLongitude := (longitude2 - longitude1) * toRadians
+	a := math.Pow(math.Sin(dLatitude/2), 2) +
+		math.Cos(latitude1*toRadians)*math.Cos(latitude2*toRadians)*math.Pow(math.Sin(dLongitude/2), 2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// Zone of the nearest big city or zone with offset by longitude
+func timezoneByLocation(latitude, longitude float64) string {
+
+	nearest := ""
+	nearestDistance := math.Inf(1)
+	for _, city := range timezoneCities {
+		if d := distance(latitude, longitude, city.latitude, city.longitude); d < nearestDistance {
+			nearest = city.timezone
+			nearestDistance = d
+		}
+	}
+	if nearestDistance <= maxCityDistance {
+		return nearest
+	}
+
+	// Etc zones have inverted sign, Etc/GMT-3 is three hours ahead of UTC
+	offset := int(math.Round(longitude / 15))
+	if offset == 0 {
+		return "Etc/GMT"
+	}
+
+	return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+// Local is rejected, it is zone of server and not of user
+func loadTimezone(name string) (*time.Location, error) {
+
+	name = strings.TrimSpace(name)
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+
+	return time.LoadLocation(name)
+}
+
+// Timezone of user, default location if user hasn't chosen one
+func userLocation(userId int) *time.Location {
+
+	if userLocation := sessions.Get(userId).Location; userLocation != nil {
+		return userLocation
+	}
+
+	userLocation := location
+	user, err := loadUserFromBase(userId)
+	if err == nil && user.Timezone != "" {
+		if userLocation, err = loadTimezone(user.Timezone); err != nil {
+			log.Printf("err: %v\n", err)
+			userLocation = location
+		}
+	}
+
+	sessions.Update(userId, func(session *Session) {
+		session.Location = userLocation
+	})
+
+	return userLocation
+}
+
+// Current time in user timezone, it decides which day an answer belongs to
+func userNow(userId int) time.Time {
+	return time.Now().In(userLocation(userId))
+}
+
+func dumpTimezoneToBase(userId int, timezone string) error {
+
+	_, err := usersCollection.UpdateOne(
+		context.TODO(),
+		bson.M{"user.id": userId},
+		bson.M{"$set": bson.M{"timezone": timezone}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func askForTimezone(bot *tgbotapi.BotAPI, userId int) {
+
+	setState(userId, AwaitingTimezone)
+
+	zonesKeyboard := tgbotapi.NewInlineKeyboardMarkup()
+	for i := 0; i < len(commonTimezones); i += 2 {
+		row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(commonTimezones[i], "setTimezone"+commonTimezones[i]))
+		if i+1 < len(commonTimezones) {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(commonTimezones[i+1], "setTimezone"+commonTimezones[i+1]))
+		}
+		zonesKeyboard.InlineKeyboard = append(zonesKeyboard.InlineKeyboard, row)
+	}
+
+	msg := tgbotapi.NewMessage(int64(userId), "Current timezone: "+userLocation(userId).String()+"\nPick your timezone:")
+	msg.ReplyMarkup = zonesKeyboard
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("err: %v\n", err)
+	}
+
+	// Location can be sent only with reply keyboard
+	msg = tgbotapi.NewMessage(int64(userId), "Or send timezone name like Europe/Kiev, or send your location. Use /cancel to stop waiting.")
+	msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButtonLocation("Send location")))
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("err: %v\n", err)
+	}
+}
+
+func setTimezone(bot *tgbotapi.BotAPI, userId int, timezone string) error {
+
+	userLocation, err := loadTimezone(timezone)
+	if err != nil {
+		showMessage(bot, userId, "Unknown timezone, send name like Europe/Kiev or use /cancel.")
+		return err
+	}
+
+	if err = dumpTimezoneToBase(userId, userLocation.String()); err != nil {
+		return err
+	}
+	sessions.Update(userId, func(session *Session) {
+		session.Location = userLocation
+	})
+	setState(userId, Idle)
+	updateReminds(userId)
+
+	msg := tgbotapi.NewMessage(int64(userId), "Timezone is set: "+userLocation.String()+
+		"\nYour time: "+time.Now().In(userLocation).Format("15:04"))
+	msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+	if _, err = bot.Send(msg); err != nil {
+		return err
+	}
+
+	return nil
+}